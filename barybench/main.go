@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// barybench puts numbers on the speedup the course keeps promising. It generates body
+// files of increasing sizes, just like genBodies does, then times loading them and
+// reducing them with each strategy, at each GOMAXPROCS setting, and prints a table of
+// the results:
+//
+//	barybench -sizes 10000,100000,1000000 -procs 1,2,4,8
+//
+// Speedup is measured against the linear version on the same input, and efficiency is
+// the speedup divided by GOMAXPROCS: 100% means every extra processor pulls its weight.
+// Each case is run in batches that grow until one takes -benchtime, the way "go test -bench"
+// does it. The strategies and loaders have Go benchmarks of their own in the barycenter
+// package, for benchstat and friends; barybench is for the table, and for profiling.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// An input is one generated system of bodies, both in memory and in a file.
+type input struct {
+	points []barycenter.MassPoint
+	file   string
+}
+
+// A benchCase is one way of loading or reducing an input.
+type benchCase struct {
+	// phase is what's being timed: "load" or "compute".
+	phase string
+	name  string
+	// scales says whether the case uses more than one processor, and so is worth running
+	// at every GOMAXPROCS setting. The linear baselines are only run once.
+	scales bool
+	// prepare sets the case up for an input, and returns what to time. If reset isn't
+	// nil, it's called before every run, off the clock.
+	prepare func(in input, procs int) (run func() error, reset func())
+}
+
+// loadCase times loading the input's file with a loader.
+func loadCase(name string, scales bool, load func(file string, procs int) error) benchCase {
+	return benchCase{phase: "load", name: name, scales: scales, prepare: func(in input, procs int) (func() error, func()) {
+		return func() error { return load(in.file, procs) }, nil
+	}}
+}
+
+// strategyCase times reducing the points with a strategy.
+func strategyCase(name string, scales bool, s func(procs int) barycenter.Strategy) benchCase {
+	return benchCase{phase: "compute", name: name, scales: scales, prepare: func(in input, procs int) (func() error, func()) {
+		strategy := s(procs)
+		return func() error {
+			_, err := strategy.Compute(in.points)
+			return err
+		}, nil
+	}}
+}
+
+// cases are all the cases barybench knows, in the order they're printed.
+// Each phase's first case is the baseline the others are measured against.
+var cases = []benchCase{
+	loadCase("linear", false, func(file string, procs int) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, _, err = barycenter.Load(f, barycenter.LoadOptions{})
+		return err
+	}),
+	// The chunked loader parses each worker's byte range through a bufio.Reader of its own;
+	// the mapped loader maps the whole file into memory and parses the ranges in place.
+	loadCase("chunked", true, func(file string, procs int) error {
+		_, _, err := barycenter.LoadFile(file, barycenter.LoadOptions{Workers: procs, Buffered: true})
+		return err
+	}),
+	loadCase("mapped", true, func(file string, procs int) error {
+		_, _, err := barycenter.LoadFile(file, barycenter.LoadOptions{Workers: procs})
+		return err
+	}),
+	loadCase("columnar", true, func(file string, procs int) error {
+		_, _, err := barycenter.LoadColumnsFile(file, barycenter.LoadOptions{Workers: procs})
+		return err
+	}),
+	strategyCase("linear", false, func(int) barycenter.Strategy { return barycenter.Linear{} }),
+	strategyCase("concurrent", true, func(int) barycenter.Strategy { return barycenter.Concurrent{} }),
+	strategyCase("chunked", true, func(procs int) barycenter.Strategy { return barycenter.Chunked{Workers: procs} }),
+	strategyCase("columnar", true, func(procs int) barycenter.Strategy { return barycenter.Columnar{Workers: procs} }),
+	// Reducing columns in place doesn't allocate at all, but it does use the columns up,
+	// so each run gets a fresh copy, off the clock.
+	{phase: "compute", name: "inplace", scales: true, prepare: func(in input, procs int) (func() error, func()) {
+		columns := barycenter.BodiesOf(in.points)
+		work := barycenter.NewBodies(columns.Len())
+		run := func() error {
+			_, err := work.Reduce(procs)
+			return err
+		}
+		reset := func() {
+			work.X = append(work.X[:0], columns.X...)
+			work.Y = append(work.Y[:0], columns.Y...)
+			work.Z = append(work.Z[:0], columns.Z...)
+			work.Mass = append(work.Mass[:0], columns.Mass...)
+		}
+		return run, reset
+	}},
+}
+
+// A measurement is the timing of the last batch of runs of a case.
+type measurement struct {
+	runs    int
+	elapsed time.Duration
+	// allocs and bytes count the allocations made during the batch.
+	allocs, bytes uint64
+}
+
+func (m measurement) perOp() time.Duration { return m.elapsed / time.Duration(m.runs) }
+func (m measurement) allocsPerOp() uint64  { return m.allocs / uint64(m.runs) }
+func (m measurement) bytesPerOp() uint64   { return m.bytes / uint64(m.runs) }
+
+// maxRuns caps the size of a batch, for cases too quick for the clock to see.
+const maxRuns = 1e9
+
+// measure runs a case in batches that grow until one takes at least benchtime, and reports
+// on that last batch. Only run is timed, but the allocations reset makes are counted too,
+// so it shouldn't make any.
+func measure(run func() error, reset func(), benchtime time.Duration) (measurement, error) {
+	n := 1
+	for {
+		m, err := measureBatch(run, reset, n)
+		if err != nil || m.elapsed >= benchtime || n >= maxRuns {
+			return m, err
+		}
+		// Aim a little past benchtime, going by this batch, but grow by a hundred times at most.
+		next := 100 * n
+		if m.elapsed > 0 {
+			if predicted := int(int64(benchtime) * int64(n) * 6 / 5 / int64(m.elapsed)); predicted < next {
+				next = predicted
+			}
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+// measureBatch times n runs of a case.
+func measureBatch(run func() error, reset func(), n int) (measurement, error) {
+	// Start each batch from a clean heap, so one batch's garbage isn't collected on the next's time.
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	m := measurement{runs: n}
+	for i := 0; i < n; i++ {
+		if reset != nil {
+			reset()
+		}
+		start := time.Now()
+		err := run()
+		m.elapsed += time.Since(start)
+		if err != nil {
+			return m, err
+		}
+	}
+	runtime.ReadMemStats(&after)
+	m.allocs = after.Mallocs - before.Mallocs
+	m.bytes = after.TotalAlloc - before.TotalAlloc
+	return m, nil
+}
+
+// A result is the timing of one case, on one input, at one GOMAXPROCS setting.
+type result struct {
+	benchCase
+	size, procs int
+	measurement
+}
+
+// parseInts reads a comma-separated list of positive numbers.
+func parseInts(list string) ([]int, error) {
+	var ns []int
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("bad number %q in %q", s, list)
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// defaultProcs lists the powers of two up to the number of CPUs, and the number of CPUs itself.
+func defaultProcs() string {
+	var procs []string
+	n := runtime.NumCPU()
+	for p := 1; p < n; p *= 2 {
+		procs = append(procs, strconv.Itoa(p))
+	}
+	return strings.Join(append(procs, strconv.Itoa(n)), ",")
+}
+
+// selectCases picks out the cases named in a comma-separated list of phase/name, just
+// name, which picks that case in every phase it's in, or just phase. "all" picks everything.
+// Whatever order they're named in, they run in the order they're listed in cases.
+func selectCases(list string) ([]benchCase, error) {
+	if list == "all" {
+		return cases, nil
+	}
+	names := strings.Split(list, ",")
+	used := make([]bool, len(names))
+	var picked []benchCase
+	for _, c := range cases {
+		for i, name := range names {
+			if name == c.phase || name == c.name || name == c.phase+"/"+c.name {
+				picked = append(picked, c)
+				used[i] = true
+				break
+			}
+		}
+	}
+	for i, name := range names {
+		if !used[i] {
+			return nil, fmt.Errorf("unknown case %q", name)
+		}
+	}
+	return picked, nil
+}
+
+// generate writes n random bodies to a file in dir, and keeps them in memory too.
+func generate(dir string, n int, seed int64) (input, error) {
+	in := input{
+		points: barycenter.RandomMassPoints(n, seed),
+		file:   filepath.Join(dir, fmt.Sprintf("bodies-%d.txt", n)),
+	}
+	f, err := os.Create(in.file)
+	if err != nil {
+		return input{}, err
+	}
+	w := bufio.NewWriter(f)
+	err = barycenter.WriteBodies(w, in.points, barycenter.Text)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return in, err
+}
+
+func main() {
+	sizesList := flag.String("sizes", "10000,100000,1000000", "comma-separated numbers of bodies to benchmark with")
+	procsList := flag.String("procs", defaultProcs(), "comma-separated GOMAXPROCS settings to run the concurrent cases at")
+	caseList := flag.String("cases", "all", "comma-separated cases to run, like load,compute/chunked, or all")
+	benchtime := flag.Duration("benchtime", time.Second, "how long to run each case for")
+	seed := flag.Int64("seed", 1, "seed for the generated bodies")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile of the benchmarks to this file")
+	traceFile := flag.String("trace", "", "write an execution trace of the benchmarks to this file")
+	flag.Parse()
+
+	sizes, err := parseInts(*sizesList)
+	handle(err)
+	procs, err := parseInts(*procsList)
+	handle(err)
+	picked, err := selectCases(*caseList)
+	handle(err)
+
+	dir, err := os.MkdirTemp("", "barybench")
+	handle(err)
+	defer os.RemoveAll(dir)
+
+	// The profiles cover the benchmarks themselves, but not generating the input.
+	inputs := make([]input, len(sizes))
+	for i, n := range sizes {
+		fmt.Fprintf(os.Stderr, "Generating %d bodies...\n", n)
+		inputs[i], err = generate(dir, n, *seed)
+		handle(err)
+	}
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		handle(err)
+		defer f.Close()
+		handle(pprof.StartCPUProfile(f))
+		defer pprof.StopCPUProfile()
+	}
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		handle(err)
+		defer f.Close()
+		handle(trace.Start(f))
+		defer trace.Stop()
+	}
+
+	var results []result
+	for i, in := range inputs {
+		for _, c := range picked {
+			settings := procs
+			if !c.scales {
+				settings = []int{1}
+			}
+			for _, p := range settings {
+				fmt.Fprintf(os.Stderr, "Running %s/%s on %d bodies with GOMAXPROCS=%d...\n", c.phase, c.name, sizes[i], p)
+				runtime.GOMAXPROCS(p)
+				run, reset := c.prepare(in, p)
+				m, err := measure(run, reset, *benchtime)
+				handle(err)
+				results = append(results, result{benchCase: c, size: sizes[i], procs: p, measurement: m})
+			}
+		}
+	}
+	handle(writeTable(os.Stdout, results))
+}
+
+// writeTable prints the results, with each one's speedup and efficiency measured against
+// the first case of the same phase on the same input. If that case wasn't run, there's
+// nothing to measure against, and those columns are left blank.
+func writeTable(w io.Writer, results []result) error {
+	type key struct {
+		phase string
+		size  int
+	}
+	baselines := make(map[key]time.Duration)
+	for _, r := range results {
+		k := key{r.phase, r.size}
+		if _, ok := baselines[k]; !ok && r.name == firstCase(r.phase) {
+			baselines[k] = r.perOp()
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "bodies\tphase\tcase\tprocs\ttime/op\tallocs/op\tMB/op\tspeedup\tefficiency\t")
+	for _, r := range results {
+		perOp := r.perOp()
+		speedup, efficiency := "", ""
+		if base, ok := baselines[key{r.phase, r.size}]; ok && perOp > 0 {
+			s := float64(base) / float64(perOp)
+			speedup = fmt.Sprintf("%.2fx", s)
+			efficiency = fmt.Sprintf("%.0f%%", 100*s/float64(r.procs))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%d\t%.1f\t%s\t%s\t\n", r.size, r.phase, r.name, r.procs,
+			perOp.Round(time.Microsecond), r.allocsPerOp(), float64(r.bytesPerOp())/(1<<20), speedup, efficiency)
+	}
+	return tw.Flush()
+}
+
+// firstCase returns the name of the baseline case of a phase.
+func firstCase(phase string) string {
+	for _, c := range cases {
+		if c.phase == phase {
+			return c.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package barycenter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// An Accumulator keeps the barycenter of a system whose bodies come and go, without
+// going back over the bodies that stayed. It holds a WeightedSum, so adding or removing
+// a body costs the same however many there are, and it's safe to use from several
+// goroutines at once. The zero Accumulator is empty and ready to use.
+type Accumulator struct {
+	mu  sync.Mutex
+	sum WeightedSum
+}
+
+// Add adds a body to the system.
+func (a *Accumulator) Add(p MassPoint) {
+	a.mu.Lock()
+	a.sum.Add(p)
+	a.mu.Unlock()
+}
+
+// Remove takes a body back out of the system. It must be a body that was added, with
+// the same values; the accumulator doesn't keep the bodies, so it can't check. The sums
+// left behind are close to, but not always exactly, what they'd be without the body.
+func (a *Accumulator) Remove(p MassPoint) {
+	a.mu.Lock()
+	a.sum.remove(p)
+	a.mu.Unlock()
+}
+
+// Merge adds every body in other to a. Other is read at one moment and not changed,
+// so the two accumulators can each be merged into the other without deadlocking.
+func (a *Accumulator) Merge(other *Accumulator) {
+	sum := other.Snapshot()
+	a.mu.Lock()
+	a.sum.Merge(sum)
+	a.mu.Unlock()
+}
+
+// Snapshot returns the sums as they are now. Its Barycenter method gives the barycenter
+// of the bodies in the system, and Count says how many there are.
+func (a *Accumulator) Snapshot() WeightedSum {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sum
+}
+
+// ErrOutOfOrder is returned when a body is added to a Window at an earlier time than
+// one that's already in it.
+var ErrOutOfOrder = errors.New("barycenter: body is older than the window's latest")
+
+// A Window keeps the barycenter of the bodies seen in the last stretch of a time-ordered
+// stream. Bodies are added with the time they were seen, and drop out once they're more
+// than the window's span older than the latest time. Like an Accumulator, it's safe to use
+// from several goroutines at once, and it costs O(1) per body, counting the one removal.
+type Window struct {
+	span time.Duration
+
+	mu  sync.Mutex
+	sum WeightedSum
+	// entries holds the bodies in the window as a ring buffer, oldest first, starting at head.
+	entries []windowEntry
+	head, n int
+	latest  time.Time
+}
+
+type windowEntry struct {
+	at time.Time
+	p  MassPoint
+}
+
+// NewWindow returns an empty window that keeps bodies for span.
+func NewWindow(span time.Duration) *Window {
+	return &Window{span: span}
+}
+
+// Add adds a body seen at t, and drops the bodies that are now too old. Times must not go
+// backwards; a body older than the latest one is refused with ErrOutOfOrder.
+func (w *Window) Add(t time.Time, p MassPoint) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.Before(w.latest) {
+		return ErrOutOfOrder
+	}
+	w.latest = t
+
+	if w.n == len(w.entries) {
+		w.grow()
+	}
+	w.entries[(w.head+w.n)%len(w.entries)] = windowEntry{t, p}
+	w.n++
+	w.sum.Add(p)
+	w.expire()
+	return nil
+}
+
+// Advance moves the window on to t without adding a body, dropping the bodies that are
+// now too old. Times before the latest one are ignored.
+func (w *Window) Advance(t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.After(w.latest) {
+		w.latest = t
+		w.expire()
+	}
+}
+
+// Snapshot returns the sums for the bodies in the window now, like Accumulator.Snapshot.
+func (w *Window) Snapshot() WeightedSum {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sum
+}
+
+// Len returns the number of bodies in the window.
+func (w *Window) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.n
+}
+
+// expire drops bodies from the front of the window until the oldest is within its span.
+func (w *Window) expire() {
+	cutoff := w.latest.Add(-w.span)
+	for w.n > 0 && w.entries[w.head].at.Before(cutoff) {
+		w.sum.remove(w.entries[w.head].p)
+		w.entries[w.head] = windowEntry{}
+		w.head = (w.head + 1) % len(w.entries)
+		w.n--
+	}
+}
+
+// grow doubles the ring buffer, moving the bodies in it to the front.
+func (w *Window) grow() {
+	entries := make([]windowEntry, 2*len(w.entries)+8)
+	for i := 0; i < w.n; i++ {
+		entries[i] = w.entries[(w.head+i)%len(w.entries)]
+	}
+	w.entries, w.head = entries, 0
+}
@@ -0,0 +1,197 @@
+package barycenter
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closeToPoint reports whether got and want are within closeTo of each other in every value.
+func closeToPoint(got, want MassPoint) bool {
+	return closeTo(got.X, want.X) && closeTo(got.Y, want.Y) && closeTo(got.Z, want.Z) && closeTo(got.Mass, want.Mass)
+}
+
+func TestAccumulatorRemoveUndoesAdd(t *testing.T) {
+	var a Accumulator
+	for _, p := range RandomMassPoints(1000, 1) {
+		a.Add(p)
+	}
+	before, err := a.Snapshot().Barycenter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := []MassPoint{{1e6, -1e6, 3, 1e3}, {1e-6, 2e-6, 3e-6, 1e-9}, {-5, 5, 0, 0.5}}
+	for _, p := range extra {
+		a.Add(p)
+	}
+	for i := len(extra) - 1; i >= 0; i-- {
+		a.Remove(extra[i])
+	}
+	after, err := a.Snapshot().Barycenter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Snapshot().Count != 1000 || !closeToPoint(after, before) {
+		t.Errorf("after adding and removing bodies, got %v, want %v", after, before)
+	}
+
+	// Taking every body back out leaves the accumulator empty, with nothing left over.
+	for _, p := range RandomMassPoints(1000, 1) {
+		a.Remove(p)
+	}
+	if sum := a.Snapshot(); sum != (WeightedSum{}) {
+		t.Errorf("after removing every body, got %+v, want an empty sum", sum)
+	}
+}
+
+// Run with -race: Add, Remove, Merge and Snapshot should all be safe to call at once.
+func TestAccumulatorConcurrent(t *testing.T) {
+	const workers, perWorker = 8, 1000
+	points := RandomMassPoints(workers*perWorker, 2)
+	var a, other Accumulator
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(part []MassPoint) {
+			defer wg.Done()
+			for _, p := range part {
+				a.Add(p)
+				other.Add(p)
+			}
+			// Each worker takes back out the second half of what it added to a.
+			for _, p := range part[len(part)/2:] {
+				a.Remove(p)
+			}
+			a.Snapshot()
+		}(points[w*perWorker : (w+1)*perWorker])
+	}
+	// Merging the two both ways at once mustn't deadlock, and merging what other has so
+	// far into a scratch accumulator mustn't race with the workers.
+	var scratch Accumulator
+	for i := 0; i < 10; i++ {
+		scratch.Merge(&other)
+		other.Merge(&scratch)
+	}
+	wg.Wait()
+
+	var kept []MassPoint
+	for w := 0; w < workers; w++ {
+		kept = append(kept, points[w*perWorker:w*perWorker+perWorker/2]...)
+	}
+	want, err := Linear{}.Compute(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.Snapshot().Barycenter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Snapshot().Count != len(kept) || !closeToPoint(got, want) {
+		t.Errorf("got %v from %d bodies, want %v from %d", got, a.Snapshot().Count, want, len(kept))
+	}
+}
+
+func TestAccumulatorMergeItself(t *testing.T) {
+	var a Accumulator
+	a.Add(MassPoint{1, 2, 3, 4})
+	a.Add(MassPoint{-1, 0, 1, 2})
+	done := make(chan struct{})
+	go func() {
+		a.Merge(&a)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("merging an accumulator into itself deadlocked")
+	}
+	sum := a.Snapshot()
+	if sum.Count != 4 || sum.Mass != 12 {
+		t.Errorf("got %d bodies with mass %g, want 4 with mass 12", sum.Count, sum.Mass)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	w := NewWindow(10 * time.Second)
+
+	// Twenty bodies a second apart, so the ring buffer has to grow.
+	points := RandomMassPoints(20, 3)
+	for i, p := range points {
+		if err := w.Add(at(i), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Bodies exactly the span old are kept: the window holds seconds 9 to 19.
+	check := func(what string, want []MassPoint) {
+		t.Helper()
+		if w.Len() != len(want) {
+			t.Fatalf("%s: got %d bodies, want %d", what, w.Len(), len(want))
+		}
+		sum := w.Snapshot()
+		if len(want) == 0 {
+			if sum != (WeightedSum{}) {
+				t.Errorf("%s: got %+v, want an empty sum", what, sum)
+			}
+			return
+		}
+		got, err := sum.Barycenter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantCenter, err := Linear{}.Compute(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !closeToPoint(got, wantCenter) {
+			t.Errorf("%s: got %v, want %v", what, got, wantCenter)
+		}
+	}
+	check("after adding", points[9:])
+
+	if err := w.Add(at(18), MassPoint{1, 1, 1, 1}); !errors.Is(err, ErrOutOfOrder) {
+		t.Errorf("adding an old body: got error %v, want ErrOutOfOrder", err)
+	}
+	check("after refusing an old body", points[9:])
+
+	// Moving back in time does nothing; moving forward drops the old bodies.
+	w.Advance(at(5))
+	check("after advancing backwards", points[9:])
+	w.Advance(at(25))
+	check("after advancing", points[15:])
+	w.Advance(at(60))
+	check("after everything expired", nil)
+
+	if err := w.Add(at(60), MassPoint{1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	check("after starting again", []MassPoint{{1, 2, 3, 4}})
+}
+
+// Run with -race: a Window should be safe to add to and read from at once.
+func TestWindowConcurrent(t *testing.T) {
+	start := time.Now()
+	w := NewWindow(time.Hour)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, p := range RandomMassPoints(500, 4) {
+				// Each goroutine's times are its own, so some adds are out of order.
+				err := w.Add(start.Add(time.Duration(p.Mass*1e6)), p)
+				if err != nil && !errors.Is(err, ErrOutOfOrder) {
+					t.Error(err)
+				}
+				w.Snapshot()
+				w.Len()
+			}
+		}()
+	}
+	wg.Wait()
+	if w.Len() != w.Snapshot().Count {
+		t.Errorf("window holds %d bodies but its sum counts %d", w.Len(), w.Snapshot().Count)
+	}
+}
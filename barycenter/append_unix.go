@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package barycenter
+
+import (
+	"os"
+	"syscall"
+)
+
+// appending reports whether f was opened for appending, whether by us or, for standard
+// output, by the shell. If we can't tell, it says it was, to be on the safe side.
+func appending(f *os.File) bool {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return true
+	}
+	var flags uintptr
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		flags, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+	}); err != nil || errno != 0 {
+		return true
+	}
+	return flags&syscall.O_APPEND != 0
+}
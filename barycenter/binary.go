@@ -0,0 +1,523 @@
+package barycenter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// The binary body format is a header followed by packed little-endian float64 records.
+//
+// The header is:
+//
+//	offset  size  contents
+//	0       4     magic, "\x00BDY" (a text body file can never start with a NUL)
+//	4       2     format version, currently 1
+//	6       2     number of fields per record
+//	8       8     number of records, or all ones if the writer didn't know
+//	16      n     one field code per field, in record order
+//
+// padded with zeros to a multiple of 8 bytes. Each record is then one float64 per field.
+// Readers skip fields they don't know, so new fields can be added without a new version.
+const (
+	binaryMagic   = "\x00BDY"
+	binaryVersion = 1
+)
+
+// Field codes for the binary header. The velocity fields are optional; bodies in files
+// without them are at rest.
+const (
+	FieldX    byte = 'x'
+	FieldY    byte = 'y'
+	FieldZ    byte = 'z'
+	FieldMass byte = 'm'
+	FieldVX   byte = 'u'
+	FieldVY   byte = 'v'
+	FieldVZ   byte = 'w'
+)
+
+// massPointFields is the record layout written for MassPoints, and bodyFields the
+// layout written for Bodies. Both are in the same order as fieldNames.
+var (
+	massPointFields = []byte{FieldX, FieldY, FieldZ, FieldMass}
+	bodyFields      = []byte{FieldX, FieldY, FieldZ, FieldMass, FieldVX, FieldVY, FieldVZ}
+)
+
+// unknownCount is stored in the header when the number of records wasn't known up front.
+const unknownCount = math.MaxUint64
+
+// Format is a body file format.
+type Format int
+
+const (
+	// Text is the x:y:z:mass line format written by genBodies.
+	Text Format = iota
+	// Binary is the packed little-endian format described above.
+	Binary
+)
+
+var formatNames = [...]string{"text", "binary"}
+
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formatNames) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formatNames[f]
+}
+
+// ParseFormat looks up a format by name: text or binary.
+func ParseFormat(name string) (Format, error) {
+	for i, n := range formatNames {
+		if n == name {
+			return Format(i), nil
+		}
+	}
+	return 0, fmt.Errorf("barycenter: unknown format %q", name)
+}
+
+// binaryHeader is the decoded header of a binary body file.
+type binaryHeader struct {
+	fields []byte
+	count  int64 // -1 if unknown
+	size   int64 // length of the header in bytes
+	// index holds the position of each of bodyFields in a record, or -1 for a missing velocity.
+	index [len(fieldNames)]int
+}
+
+// recordSize is the length of one record in bytes.
+func (h binaryHeader) recordSize() int64 {
+	return int64(len(h.fields)) * 8
+}
+
+// decode decodes one record.
+func (h binaryHeader) decode(rec []byte) Body {
+	var vals [len(fieldNames)]float64
+	for i, at := range h.index {
+		if at >= 0 {
+			vals[i] = math.Float64frombits(binary.LittleEndian.Uint64(rec[at*8:]))
+		}
+	}
+	return Body{
+		MassPoint: MassPoint{vals[0], vals[1], vals[2], vals[3]},
+		Velocity:  Vector{vals[4], vals[5], vals[6]},
+	}
+}
+
+// check applies opts.Validation to the record numbered n, which decoded to b.
+func (h binaryHeader) check(b Body, n int64, opts LoadOptions) *ParseError {
+	field, perr := checkBody(b, opts.Validation)
+	if perr != nil {
+		perr.Name = opts.Name
+		perr.Line = int(n)
+		perr.Column = h.index[field]*8 + 1
+		perr.Text = string(bytes.TrimRight(AppendBodyText(nil, b), "\n"))
+	}
+	return perr
+}
+
+// records works out how many records follow the header in a file of size bytes. It's false
+// unless the records fill the rest of the file exactly, and there are as many of them as
+// the header says, if it says.
+func (h binaryHeader) records(size int64) (int64, bool) {
+	recSize := h.recordSize()
+	count := (size - h.size) / recSize
+	if size < h.size || (size-h.size)%recSize != 0 || h.count >= 0 && count != h.count {
+		return 0, false
+	}
+	return count, true
+}
+
+// errTruncated is the complaint about a binary body file with the wrong number of bytes.
+func errTruncated(name string) error {
+	return fmt.Errorf("%s: binary body file is truncated or has trailing data", name)
+}
+
+// headerSize is the length of a header with n fields, padding included.
+func headerSize(n int) int64 {
+	return int64(16+n+7) &^ 7
+}
+
+// encodeBinaryHeader encodes a header for records with the given fields.
+// A negative count is stored as unknown.
+func encodeBinaryHeader(fields []byte, count int64) []byte {
+	buf := make([]byte, headerSize(len(fields)))
+	copy(buf, binaryMagic)
+	binary.LittleEndian.PutUint16(buf[4:], binaryVersion)
+	binary.LittleEndian.PutUint16(buf[6:], uint16(len(fields)))
+	stored := uint64(unknownCount)
+	if count >= 0 {
+		stored = uint64(count)
+	}
+	binary.LittleEndian.PutUint64(buf[8:], stored)
+	copy(buf[16:], fields)
+	return buf
+}
+
+// readBinaryHeader decodes the header at the start of r.
+func readBinaryHeader(r io.Reader) (binaryHeader, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return binaryHeader{}, fmt.Errorf("barycenter: reading binary header: %w", err)
+	}
+	if string(fixed[:4]) != binaryMagic {
+		return binaryHeader{}, errors.New("barycenter: not a binary body file")
+	}
+	if v := binary.LittleEndian.Uint16(fixed[4:]); v != binaryVersion {
+		return binaryHeader{}, fmt.Errorf("barycenter: unsupported binary format version %d", v)
+	}
+
+	n := int(binary.LittleEndian.Uint16(fixed[6:]))
+	h := binaryHeader{count: -1, size: headerSize(n)}
+	if c := binary.LittleEndian.Uint64(fixed[8:]); c != unknownCount {
+		h.count = int64(c)
+	}
+	rest := make([]byte, h.size-16)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return binaryHeader{}, fmt.Errorf("barycenter: reading binary header: %w", err)
+	}
+	h.fields = rest[:n]
+
+	for i, want := range bodyFields {
+		h.index[i] = -1
+		for j, f := range h.fields {
+			if f == want {
+				h.index[i] = j
+			}
+		}
+		if h.index[i] < 0 && i < len(massPointFields) {
+			return binaryHeader{}, fmt.Errorf("barycenter: binary body file has no %s field", fieldNames[i])
+		}
+	}
+	return h, nil
+}
+
+// sniffBinary wraps r in a buffered reader, and peeks at it to see whether it holds
+// the binary format.
+func sniffBinary(r io.Reader) (*bufio.Reader, bool) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(binaryMagic))
+	return br, string(magic) == binaryMagic
+}
+
+// isBinaryAt reports whether r starts with the binary format's magic.
+func isBinaryAt(r io.ReaderAt) bool {
+	magic := make([]byte, len(binaryMagic))
+	_, err := r.ReadAt(magic, 0)
+	return err == nil && string(magic) == binaryMagic
+}
+
+// scanBinary reads binary records from br one at a time, into s.
+func scanBinary(br *bufio.Reader, opts LoadOptions, s sink) (Rejects, error) {
+	h, err := readBinaryHeader(br)
+	if err != nil {
+		return Rejects{}, err
+	}
+	var rejects Rejects
+	rec := make([]byte, h.recordSize())
+	var n int64
+	for ; h.count < 0 || n < h.count; n++ {
+		_, err := io.ReadFull(br, rec)
+		if err == io.EOF && h.count < 0 {
+			return rejects, nil
+		} else if err != nil {
+			return Rejects{}, fmt.Errorf("%s: record %d: %w", opts.Name, n+1, err)
+		}
+		b := h.decode(rec)
+		if perr := h.check(b, n+1, opts); perr != nil {
+			if err := rejects.note(perr, opts); err != nil {
+				return Rejects{}, err
+			}
+			if !perr.flagged {
+				continue
+			}
+		}
+		s.addBody(b)
+	}
+	// The header's count is all there should be.
+	if _, err := br.ReadByte(); err != io.EOF {
+		if err == nil {
+			err = errTruncated(opts.Name)
+		}
+		return Rejects{}, err
+	}
+	return rejects, nil
+}
+
+// scanBinaryRanges splits the records in size bytes of r into one range per sink, and
+// decodes each range into its own sink in its own goroutine. Records all have the same
+// length, so unlike text the ranges can start exactly on a record.
+// Records are numbered from the start of the file, so their rejects need no renumbering.
+func scanBinaryRanges(r io.ReaderAt, size int64, opts LoadOptions, sinks []sink) (Rejects, error) {
+	h, err := readBinaryHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return Rejects{}, err
+	}
+	recSize := h.recordSize()
+	count, ok := h.records(size)
+	if !ok {
+		return Rejects{}, errTruncated(opts.Name)
+	}
+
+	n := int64(len(sinks))
+	results := make([]Rejects, n)
+	errs := make([]error, n)
+	// failed is the index of the first range to hit a strict mode failure, as in scanRanges.
+	failed := n
+	var wg sync.WaitGroup
+	for i := int64(0); i < n; i++ {
+		first := count * i / n
+		last := count * (i + 1) / n
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			// A mapped file's records are decoded where they are, rather than copied out.
+			var readNext func() ([]byte, error)
+			if m, ok := r.(mappedFile); ok {
+				rest := []byte(m[h.size+first*recSize : h.size+last*recSize])
+				readNext = func() ([]byte, error) {
+					rec := rest[:recSize]
+					rest = rest[recSize:]
+					return rec, nil
+				}
+			} else {
+				sr := io.NewSectionReader(r, h.size+first*recSize, (last-first)*recSize)
+				br := bufio.NewReaderSize(sr, 64<<10)
+				rec := make([]byte, recSize)
+				readNext = func() ([]byte, error) {
+					_, err := io.ReadFull(br, rec)
+					return rec, err
+				}
+			}
+			j, counted := first, first
+			defer func() { opts.Progress.add((j-counted)*recSize, j-counted) }()
+			for ; j < last; j++ {
+				if atomic.LoadInt64(&failed) < i {
+					return
+				}
+				if (j-first)%checkEvery == 0 {
+					if err := opts.stopped(); err != nil {
+						errs[i] = err
+						return
+					}
+					opts.Progress.add((j-counted)*recSize, j-counted)
+					counted = j
+				}
+				rec, err := readNext()
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: record %d: %w", opts.Name, j+1, err)
+					return
+				}
+				b := h.decode(rec)
+				if perr := h.check(b, j+1, opts); perr != nil {
+					if err := results[i].note(perr, opts); err != nil {
+						errs[i] = err
+						markFailed(&failed, int(i))
+						return
+					}
+					if !perr.flagged {
+						continue
+					}
+				}
+				sinks[i].addBody(b)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var rejects Rejects
+	for i, err := range errs {
+		if err != nil {
+			return Rejects{}, err
+		}
+		rejects.merge(results[i], opts.MaxExamples)
+	}
+	return rejects, nil
+}
+
+// A BinaryWriter writes mass points in the binary body format.
+type BinaryWriter struct {
+	out     io.Writer
+	w       *bufio.Writer
+	buf     []byte
+	count   int64
+	written int64
+	// headerAt is where the header starts in out, if Flush is to fill in its count, or -1.
+	headerAt int64
+}
+
+// NewBinaryWriter writes the header for count records to w, and returns a writer
+// for the records. If count is negative, it's written as unknown; Flush fills it in
+// afterwards if w is a regular file that isn't being appended to.
+func NewBinaryWriter(w io.Writer, count int64) (*BinaryWriter, error) {
+	return newBinaryWriter(w, count, massPointFields)
+}
+
+// NewBodyWriter is like NewBinaryWriter, except the records have velocity fields too.
+func NewBodyWriter(w io.Writer, count int64) (*BinaryWriter, error) {
+	return newBinaryWriter(w, count, bodyFields)
+}
+
+func newBinaryWriter(w io.Writer, count int64, fields []byte) (*BinaryWriter, error) {
+	bw := &BinaryWriter{
+		out:   w,
+		w:     bufio.NewWriter(w),
+		buf:   make([]byte, 8*len(fields)),
+		count: count,
+	}
+	bw.headerAt = -1
+	if count < 0 {
+		bw.headerAt = headerOffset(w)
+	}
+	if _, err := bw.w.Write(encodeBinaryHeader(fields, count)); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// Write writes one record. If the records have velocity fields, the body is at rest.
+func (bw *BinaryWriter) Write(p MassPoint) error {
+	return bw.WriteBody(Body{MassPoint: p})
+}
+
+// WriteBody writes one record. If the records have no velocity fields, the velocity is dropped.
+func (bw *BinaryWriter) WriteBody(b Body) error {
+	p, vel := b.MassPoint, b.Velocity
+	vals := [...]float64{p.X, p.Y, p.Z, p.Mass, vel.X, vel.Y, vel.Z}
+	for i := 0; i*8 < len(bw.buf); i++ {
+		binary.LittleEndian.PutUint64(bw.buf[i*8:], math.Float64bits(vals[i]))
+	}
+	bw.written++
+	_, err := bw.w.Write(bw.buf)
+	return err
+}
+
+// Flush writes any buffered records, and checks that the number written matches the header.
+func (bw *BinaryWriter) Flush() error {
+	if err := bw.w.Flush(); err != nil {
+		return err
+	}
+	if bw.count >= 0 {
+		if bw.written != bw.count {
+			return fmt.Errorf("barycenter: header promised %d records, but %d were written", bw.count, bw.written)
+		}
+		return nil
+	}
+
+	// The count wasn't known up front. If we're writing to a regular file we can go back
+	// and fill it in; otherwise readers will just read until the end.
+	if bw.headerAt < 0 {
+		return nil
+	}
+	var count [8]byte
+	binary.LittleEndian.PutUint64(count[:], uint64(bw.written))
+	_, err := bw.out.(*os.File).WriteAt(count[:], bw.headerAt+8)
+	return err
+}
+
+// headerOffset returns where in w a header written now would start, if w is a file that
+// Flush can go back and write the count into, or -1 if it isn't. The header needn't be at
+// the start of the file, as when standard output has been opened partway through one,
+// but a file opened for appending is no good: every write goes on the end, wherever it's
+// aimed.
+func headerOffset(w io.Writer) int64 {
+	f, ok := w.(*os.File)
+	if !ok {
+		return -1
+	}
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() || appending(f) {
+		return -1
+	}
+	at, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return at
+}
+
+// AppendText appends p to dst as a line in the text body format. The values are written
+// with as few digits as will read back exactly.
+func AppendText(dst []byte, p MassPoint) []byte {
+	for i, v := range [4]float64{p.X, p.Y, p.Z, p.Mass} {
+		if i > 0 {
+			dst = append(dst, ':')
+		}
+		dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+	}
+	return append(dst, '\n')
+}
+
+// AppendBodyText is like AppendText, except the body's velocity is written after its mass,
+// followed by its label if it has one.
+func AppendBodyText(dst []byte, b Body) []byte {
+	dst = AppendText(dst, b.MassPoint)
+	dst = dst[:len(dst)-1]
+	for _, v := range [3]float64{b.Velocity.X, b.Velocity.Y, b.Velocity.Z} {
+		dst = append(dst, ':')
+		dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+	}
+	if b.Label != "" {
+		dst = append(dst, ':')
+		dst = append(dst, b.Label...)
+	}
+	return append(dst, '\n')
+}
+
+// WriteBodies writes points to w as a complete body file in the given format.
+func WriteBodies(w io.Writer, points []MassPoint, f Format) error {
+	if f == Binary {
+		bw, err := NewBinaryWriter(w, int64(len(points)))
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if err := bw.Write(p); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+
+	bw := bufio.NewWriter(w)
+	var line []byte
+	for _, p := range points {
+		line = AppendText(line[:0], p)
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteMovingBodies is like WriteBodies, except each body's velocity is written too.
+func WriteMovingBodies(w io.Writer, bodies []Body, f Format) error {
+	if f == Binary {
+		bw, err := NewBodyWriter(w, int64(len(bodies)))
+		if err != nil {
+			return err
+		}
+		for _, b := range bodies {
+			if err := bw.WriteBody(b); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	}
+
+	bw := bufio.NewWriter(w)
+	var line []byte
+	for _, b := range bodies {
+		line = AppendBodyText(line[:0], b)
+		if _, err := bw.Write(line); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
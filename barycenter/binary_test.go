@@ -0,0 +1,128 @@
+package barycenter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// binaryFile encodes points as a binary body file whose header claims count records,
+// or doesn't say if count is negative, followed by extra bytes of junk.
+func binaryFile(t *testing.T, points []MassPoint, count int64, extra int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	bw, err := NewBinaryWriter(&buf, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if err := bw.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return append(buf.Bytes(), make([]byte, extra)...)
+}
+
+// Every binary reader should turn down a file with bytes left over after its records.
+func TestBinaryTrailingData(t *testing.T) {
+	points := RandomMassPoints(100, 1)
+	const recSize = 32
+	for _, tc := range []struct {
+		count  int64
+		extra  int
+		wantOK bool
+	}{
+		{100, 0, true},
+		{100, 1, false},
+		{100, recSize - 1, false},
+		{100, recSize, false},
+		{-1, 0, true},
+		{-1, 1, false},
+		{-1, recSize - 1, false},
+		// Without a count, a whole extra record is just one more body.
+		{-1, recSize, true},
+	} {
+		data := binaryFile(t, points, tc.count, tc.extra)
+		opts := LoadOptions{Name: fmt.Sprintf("count %d, %d extra bytes", tc.count, tc.extra), Workers: 3}
+		_, _, streamErr := Load(bytes.NewReader(data), opts)
+		_, _, rangeErr := LoadRanges(bytes.NewReader(data), int64(len(data)), opts)
+		_, _, mappedErr := LoadRanges(mappedFile(data), int64(len(data)), opts)
+		for reader, err := range map[string]error{"stream": streamErr, "ranges": rangeErr, "mapped": mappedErr} {
+			if (err == nil) != tc.wantOK {
+				t.Errorf("%s, read by %s: got error %v, want ok = %v", opts.Name, reader, err, tc.wantOK)
+			}
+		}
+	}
+}
+
+// A binary file written after other data, by a writer that didn't know the count, should
+// have its count filled in where its header is, or, if the file is being appended to,
+// left unknown; either way the data before it must be left alone.
+func TestBinaryWriterAfterPrefix(t *testing.T) {
+	points := RandomMassPoints(10, 1)
+	prefix := []byte("earlier output\n")
+	for _, tc := range []struct {
+		name      string
+		flag      int
+		wantCount int64
+	}{
+		{"write", os.O_WRONLY, int64(len(points))},
+		{"append", os.O_WRONLY | os.O_APPEND, -1},
+	} {
+		path := filepath.Join(t.TempDir(), "bodies.bin")
+		if err := os.WriteFile(path, prefix, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, tc.flag, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.flag&os.O_APPEND == 0 {
+			if _, err := f.Seek(int64(len(prefix)), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+		}
+		bw, err := NewBinaryWriter(f, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range points {
+			if err := bw.Write(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, prefix) {
+			t.Fatalf("%s: the data before the header was overwritten: %q", tc.name, data[:len(prefix)])
+		}
+		data = data[len(prefix):]
+		if count := int64(binary.LittleEndian.Uint64(data[8:])); count != tc.wantCount {
+			t.Errorf("%s: header count is %d, want %d", tc.name, count, tc.wantCount)
+		}
+		got, _, err := Load(bytes.NewReader(data), LoadOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, points) {
+			t.Errorf("%s: read back %v, want %v", tc.name, got, points)
+		}
+	}
+}
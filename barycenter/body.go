@@ -0,0 +1,175 @@
+package barycenter
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Vector is a direction and size in 3-space, like a velocity or an acceleration.
+type Vector struct {
+	X, Y, Z float64
+}
+
+func (v Vector) add(w Vector) Vector {
+	return Vector{v.X + w.X, v.Y + w.Y, v.Z + w.Z}
+}
+
+// A Body is a mass point that's moving, and may belong to a labelled group.
+// Body lines give the velocity after the mass, as x:y:z:mass:vx:vy:vz, and binary body
+// files give it in the velocity fields. Bodies read from records without a velocity
+// are at rest. Body lines can end with a label; the binary format has no labels.
+type Body struct {
+	MassPoint
+	Velocity Vector
+	Label    string
+}
+
+// bodySink collects bodies in a slice, velocities and all.
+type bodySink struct {
+	bodies []Body
+}
+
+func (s *bodySink) addBody(b Body) { s.bodies = append(s.bodies, b) }
+
+// LoadBodies is like Load, except the bodies keep their velocities and labels.
+func LoadBodies(r io.Reader, opts LoadOptions) ([]Body, Rejects, error) {
+	var bodies bodySink
+	rejects, err := scanLines(r, opts, &bodies)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	return bodies.bodies, rejects, nil
+}
+
+// LoadBodiesFile is like LoadFile, except the bodies keep their velocities and labels.
+// Uncompressed text and binary files are split into byte ranges and loaded concurrently;
+// anything else is read with LoadBodies.
+func LoadBodiesFile(name string, opts LoadOptions) ([]Body, Rejects, error) {
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	in, err := openInput(name)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	defer in.Close()
+
+	if in.size < 0 || customDecoder(opts) != nil {
+		return LoadBodies(in, opts)
+	}
+
+	parts := make([]*bodySink, rangeCount(in.size, opts.Workers))
+	sinks := make([]sink, len(parts))
+	for i := range parts {
+		parts[i] = &bodySink{}
+		sinks[i] = parts[i]
+	}
+	rejects, err := scanRanges(in.ranges(opts), in.size, opts, sinks)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+
+	total := 0
+	for _, part := range parts {
+		total += len(part.bodies)
+	}
+	bodies := make([]Body, 0, total)
+	for _, part := range parts {
+		bodies = append(bodies, part.bodies...)
+	}
+	return bodies, rejects, nil
+}
+
+// MassPoints returns just the mass points of bodies, for the strategies.
+func MassPoints(bodies []Body) []MassPoint {
+	points := make([]MassPoint, len(bodies))
+	for i, b := range bodies {
+		points[i] = b.MassPoint
+	}
+	return points
+}
+
+// Motion is where a system's barycenter is, and how fast it's moving.
+type Motion struct {
+	// Barycenter is the virtual body at the barycenter, carrying the system's mass.
+	Barycenter MassPoint
+	// Velocity is the system's total momentum over its total mass.
+	Velocity Vector
+}
+
+// ComputeMotion finds the barycenter of bodies and the velocity it's moving at,
+// splitting the bodies between workers goroutines. If workers is zero or less,
+// GOMAXPROCS is used.
+//
+// The barycenter's velocity is the mass-weighted average of the bodies' velocities,
+// which is just the barycenter of the velocities taken as points, so each worker sums
+// both into WeightedSums, and the partial sums are merged in order.
+func ComputeMotion(bodies []Body, workers int) (Motion, error) {
+	if len(bodies) == 0 {
+		return Motion{}, ErrNoPoints
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(bodies) {
+		workers = len(bodies)
+	}
+
+	type partial struct{ pos, vel WeightedSum }
+	partials := make([]partial, workers)
+	var wg sync.WaitGroup
+	for w := range partials {
+		lo := len(bodies) * w / workers
+		hi := len(bodies) * (w + 1) / workers
+		wg.Add(1)
+		go func(part *partial) {
+			defer wg.Done()
+			for _, b := range bodies[lo:hi] {
+				part.pos.Add(b.MassPoint)
+				part.vel.Add(MassPoint{b.Velocity.X, b.Velocity.Y, b.Velocity.Z, b.Mass})
+			}
+		}(&partials[w])
+	}
+	wg.Wait()
+
+	var pos, vel WeightedSum
+	for _, part := range partials {
+		pos.Merge(part.pos)
+		vel.Merge(part.vel)
+	}
+	b, err := pos.Barycenter()
+	if err != nil {
+		return Motion{}, err
+	}
+	v, err := vel.Barycenter()
+	if err != nil {
+		return Motion{}, err
+	}
+	return Motion{Barycenter: b, Velocity: Vector{v.X, v.Y, v.Z}}, nil
+}
+
+// Advance moves every body along its velocity for dt, splitting the bodies between
+// workers goroutines. If workers is zero or less, GOMAXPROCS is used.
+// Nothing pulls on the bodies here; for that, see Simulation.
+func Advance(bodies []Body, dt float64, workers int) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo := len(bodies) * w / workers
+		hi := len(bodies) * (w + 1) / workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				b := &bodies[i]
+				b.X += b.Velocity.X * dt
+				b.Y += b.Velocity.Y * dt
+				b.Z += b.Velocity.Z * dt
+			}
+		}()
+	}
+	wg.Wait()
+}
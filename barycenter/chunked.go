@@ -0,0 +1,100 @@
+package barycenter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Chunked is the bounded concurrent strategy. Rather than spinning off a goroutine for
+// every pair of points in every round, it splits the points into one chunk per worker,
+// reduces each chunk locally in its own goroutine and then combines the partial results.
+//
+// Chunks are sized to a power of two, so each partial result is exactly the point the
+// Linear strategy would have at that position after the same number of rounds.
+// That makes the final result bit-for-bit the same as Linear's, whatever the worker count.
+type Chunked struct {
+	// Workers is the number of goroutines to reduce with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+}
+
+// Compute implements Strategy.
+func (s Chunked) Compute(points []MassPoint) (MassPoint, error) {
+	return s.computeContext(context.Background(), points)
+}
+
+func (s Chunked) computeContext(ctx context.Context, points []MassPoint) (MassPoint, error) {
+	if len(points) == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	size := chunkSize(len(points), workers)
+
+	// We reduce in place, so work on a weighted copy rather than the caller's slice.
+	buf := toWeighted(points)
+
+	partials := make([]MassPoint, (len(buf)+size-1)/size)
+	stop := ctx.Done()
+	var wg sync.WaitGroup
+	for i := range partials {
+		lo := i * size
+		hi := lo + size
+		if hi > len(buf) {
+			hi = len(buf)
+		}
+		wg.Add(1)
+		go func(i int, chunk []MassPoint) {
+			defer wg.Done()
+			partials[i] = reduceInPlace(chunk, stop)
+		}(i, buf[lo:hi])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return MassPoint{}, err
+	}
+
+	// There's at most one partial per worker, so combining them is cheap.
+	return checkResult(FromWeightedSubspace(reduceInPlace(partials, nil)))
+}
+
+// chunkSize finds the smallest power of two that splits n points into at most
+// the given number of chunks.
+func chunkSize(n, workers int) int {
+	perWorker := (n + workers - 1) / workers
+	size := 1
+	for size < perWorker {
+		size <<= 1
+	}
+	return size
+}
+
+// reduceInPlace performs the same pairwise rounds as Linear on points in the weighted
+// subspace, but writes each round's sums over the front of the slice instead of
+// allocating a new one.
+// Once stop is closed, it gives up between rounds, and the result is meaningless.
+func reduceInPlace(points []MassPoint, stop <-chan struct{}) MassPoint {
+	n := len(points)
+	for n > 1 {
+		select {
+		case <-stop:
+			return MassPoint{}
+		default:
+		}
+		half := n / 2
+		for i := 0; i < half; i++ {
+			points[i] = AddMassPoints(points[2*i], points[2*i+1])
+		}
+		// Carry the odd point out to the end, just like Linear does
+		if n%2 != 0 {
+			points[half] = points[n-1]
+			half++
+		}
+		n = half
+	}
+	return points[0]
+}
@@ -0,0 +1,64 @@
+package barycenter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChunkedMatchesLinearExactly(t *testing.T) {
+	for _, in := range strategyInputs {
+		want, wantErr := Linear{}.Compute(in.points)
+		for _, workers := range []int{1, 2, 3, 4, 7, 8, 64} {
+			got, err := Chunked{Workers: workers}.Compute(in.points)
+			if err != wantErr || got != want {
+				t.Errorf("%s with %d workers: got %v, %v, want %v, %v", in.name, workers, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// benchSizes are the numbers of bodies the strategies are benchmarked with.
+var benchSizes = []int{1000, 100000, 1000000}
+
+// benchWorkers are the worker counts the bounded strategies are benchmarked with.
+// Run with -cpu to vary GOMAXPROCS as well.
+var benchWorkers = []int{1, 2, 4, 8}
+
+// benchInputs caches the generated systems, so each size is only generated once.
+var benchInputs = map[int][]MassPoint{}
+
+func benchPoints(n int) []MassPoint {
+	if benchInputs[n] == nil {
+		benchInputs[n] = RandomMassPoints(n, 1)
+	}
+	return benchInputs[n]
+}
+
+// benchmarkStrategy times s on each of the benchmark sizes.
+func benchmarkStrategy(b *testing.B, s Strategy) {
+	for _, n := range benchSizes {
+		points := benchPoints(n)
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Compute(points); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPerPair times the Concurrent strategy, which spins off a goroutine for every pair.
+func BenchmarkPerPair(b *testing.B) {
+	benchmarkStrategy(b, Concurrent{})
+}
+
+// BenchmarkChunked times the Chunked strategy at each of the benchmark worker counts.
+func BenchmarkChunked(b *testing.B) {
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkStrategy(b, Chunked{Workers: workers})
+		})
+	}
+}
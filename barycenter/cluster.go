@@ -0,0 +1,291 @@
+package barycenter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// clusterChunk is how many points each piece of k-means work covers. The pieces are
+// the same whatever the number of workers, and their partial results are always combined
+// in the same order, so the clustering only depends on the points and the seed.
+const clusterChunk = 1 << 14
+
+// DefaultMaxIterations is how many rounds k-means runs for, at most, by default.
+const DefaultMaxIterations = 100
+
+// ClusterOptions says how to cluster bodies with KMeans.
+type ClusterOptions struct {
+	// K is the number of clusters to find.
+	K int
+	// Seed seeds the random choices of k-means++, so the same seed always gives the same clusters.
+	Seed int64
+	// Workers is the number of goroutines to share the work between.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+	// MaxIterations is how many rounds to run for, at most, if the clusters haven't
+	// settled down by then. If it's zero or less, DefaultMaxIterations is used.
+	MaxIterations int
+}
+
+// A Clustering is the result of KMeans.
+type Clustering struct {
+	// Clusters holds the virtual body at the barycenter of each cluster, carrying its
+	// mass, and how many bodies the cluster has. The label of a cluster in Groups, and
+	// in Assignments, is its index.
+	Clusters []Group
+	// Assignments holds the index of the cluster each point belongs to.
+	Assignments []int
+	// Iterations is the number of rounds run, and Converged says whether they ended
+	// because no point changed clusters.
+	Iterations int
+	Converged  bool
+	// Inertia is the mass-weighted sum of the squared distances from each point to the
+	// center of its cluster in the last round: the quantity k-means makes smaller every
+	// round. Once the clusters have converged, the centers are their barycenters.
+	Inertia float64
+}
+
+// ErrTooFewClusters is returned when asked for fewer than one cluster.
+var ErrTooFewClusters = errors.New("barycenter: need at least one cluster")
+
+// KMeans splits points into o.K clusters by mass-weighted k-means: each point belongs to
+// the cluster whose barycenter is closest, and each cluster's center is the barycenter of
+// its points, with heavier points pulling harder. It alternates between the two, Lloyd's
+// algorithm, until no point changes clusters.
+//
+// The starting centers are picked by k-means++: the first with probability proportional to
+// mass, and each of the rest with probability proportional to mass times the squared
+// distance to the nearest center picked so far. If there are fewer than K distinct
+// positions with mass, there are fewer clusters.
+//
+// Both steps are shared between the workers a chunk of points at a time. A cluster that
+// loses all of its mass keeps its old center, in case it wins some back.
+func KMeans(points []MassPoint, o ClusterOptions) (Clustering, error) {
+	if len(points) == 0 {
+		return Clustering{}, ErrNoPoints
+	}
+	if o.K < 1 {
+		return Clustering{}, ErrTooFewClusters
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.MaxIterations <= 0 {
+		o.MaxIterations = DefaultMaxIterations
+	}
+
+	centers, err := seedCenters(points, o)
+	if err != nil {
+		return Clustering{}, err
+	}
+	c := Clustering{Assignments: make([]int, len(points))}
+	for i := range c.Assignments {
+		c.Assignments[i] = -1
+	}
+	var sums []WeightedSum
+	for c.Iterations < o.MaxIterations {
+		c.Iterations++
+		var changed int
+		sums, changed, c.Inertia = assignPoints(points, centers, c.Assignments, o.Workers)
+		if changed == 0 {
+			c.Converged = true
+			break
+		}
+		for j := range centers {
+			// The mass of a cluster is the same whichever way it's summed, so there's no
+			// NaN to divide out unless the cluster has lost all of its mass.
+			if p, err := sums[j].Barycenter(); err == nil {
+				centers[j] = p
+			}
+		}
+	}
+
+	// The last round's sums are for the final assignments, whether the clusters settled
+	// down or we ran out of rounds, so they give the barycenters and masses we report.
+	c.Clusters = make([]Group, len(centers))
+	for j := range centers {
+		c.Clusters[j].Label = fmt.Sprint(j)
+		c.Clusters[j].Bodies = sums[j].Count
+		c.Clusters[j].Barycenter = MassPoint{centers[j].X, centers[j].Y, centers[j].Z, 0}
+		if p, err := sums[j].Barycenter(); err == nil {
+			c.Clusters[j].Barycenter = p
+		}
+	}
+	return c, nil
+}
+
+// forChunks calls fn for each chunk of n points, the chunk numbered i covering points
+// lo up to hi, sharing the chunks between workers goroutines.
+func forChunks(n, workers int, fn func(i, lo, hi int)) {
+	chunks := (n + clusterChunk - 1) / clusterChunk
+	if workers > chunks {
+		workers = chunks
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				lo := i * clusterChunk
+				hi := lo + clusterChunk
+				if hi > n {
+					hi = n
+				}
+				fn(i, lo, hi)
+			}
+		}()
+	}
+	for i := 0; i < chunks; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// distance2 is the squared distance between the positions of two mass points.
+func distance2(a, b MassPoint) float64 {
+	dx, dy, dz := a.X-b.X, a.Y-b.Y, a.Z-b.Z
+	return dx*dx + dy*dy + dz*dz
+}
+
+// seedCenters picks the starting centers by k-means++.
+func seedCenters(points []MassPoint, o ClusterOptions) ([]MassPoint, error) {
+	rng := rand.New(rand.NewSource(o.Seed))
+	n := len(points)
+	chunks := (n + clusterChunk - 1) / clusterChunk
+	// weights[i] is how likely point i is to be the next center, before normalizing.
+	// At first, that's just its mass.
+	weights := make([]float64, n)
+	nearest := make([]float64, n)
+	chunkTotals := make([]float64, chunks)
+	forChunks(n, o.Workers, func(c, lo, hi int) {
+		total := 0.0
+		for i := lo; i < hi; i++ {
+			weights[i] = math.Max(points[i].Mass, 0)
+			nearest[i] = math.Inf(1)
+			total += weights[i]
+		}
+		chunkTotals[c] = total
+	})
+
+	var centers []MassPoint
+	for len(centers) < o.K {
+		i, ok := pickWeighted(rng, weights, chunkTotals)
+		if !ok {
+			break
+		}
+		center := MassPoint{points[i].X, points[i].Y, points[i].Z, 0}
+		if !isFinite(center) {
+			return nil, ErrNotFinite
+		}
+		centers = append(centers, center)
+		// Each point's chance of being next depends on how far it is from the nearest center.
+		forChunks(n, o.Workers, func(c, lo, hi int) {
+			total := 0.0
+			for i := lo; i < hi; i++ {
+				if d := distance2(points[i], center); d < nearest[i] {
+					nearest[i] = d
+				}
+				weights[i] = math.Max(points[i].Mass, 0) * nearest[i]
+				total += weights[i]
+			}
+			chunkTotals[c] = total
+		})
+	}
+	if len(centers) == 0 {
+		return nil, ErrZeroMass
+	}
+	return centers, nil
+}
+
+// pickWeighted picks an index at random, with probability proportional to its weight.
+// It finds the chunk first, by the chunks' totals, and then the index within the chunk.
+// If the weights are all zero, there's nothing to pick.
+func pickWeighted(rng *rand.Rand, weights, chunkTotals []float64) (int, bool) {
+	total := 0.0
+	for _, t := range chunkTotals {
+		total += t
+	}
+	if !(total > 0) || math.IsInf(total, 0) {
+		return 0, false
+	}
+	target := rng.Float64() * total
+	last := -1
+	for c, t := range chunkTotals {
+		if t <= 0 {
+			continue
+		}
+		last = c
+		if target < t {
+			break
+		}
+		target -= t
+	}
+	// Rounding can leave target a hair past the end, so we settle for the last point with any weight.
+	lo := last * clusterChunk
+	hi := lo + clusterChunk
+	if hi > len(weights) {
+		hi = len(weights)
+	}
+	pick := -1
+	for i := lo; i < hi; i++ {
+		if weights[i] <= 0 {
+			continue
+		}
+		pick = i
+		if target < weights[i] {
+			break
+		}
+		target -= weights[i]
+	}
+	return pick, true
+}
+
+// assignPoints moves each point to the cluster with the closest center, and sums up the
+// clusters. It returns the sums, the number of points that changed clusters and the inertia.
+func assignPoints(points, centers []MassPoint, assignments []int, workers int) ([]WeightedSum, int, float64) {
+	n := len(points)
+	chunks := (n + clusterChunk - 1) / clusterChunk
+	type partial struct {
+		sums    []WeightedSum
+		changed int
+		inertia float64
+	}
+	parts := make([]partial, chunks)
+	forChunks(n, workers, func(c, lo, hi int) {
+		part := partial{sums: make([]WeightedSum, len(centers))}
+		for i := lo; i < hi; i++ {
+			best, bestD := 0, math.Inf(1)
+			for j, center := range centers {
+				if d := distance2(points[i], center); d < bestD {
+					best, bestD = j, d
+				}
+			}
+			if assignments[i] != best {
+				assignments[i] = best
+				part.changed++
+			}
+			part.sums[best].Add(points[i])
+			part.inertia += points[i].Mass * bestD
+		}
+		parts[c] = part
+	})
+
+	// The chunks are merged in order, so the sums don't depend on which worker did what.
+	sums := make([]WeightedSum, len(centers))
+	changed, inertia := 0, 0.0
+	for _, part := range parts {
+		for j := range sums {
+			sums[j].Merge(part.sums[j])
+		}
+		changed += part.changed
+		inertia += part.inertia
+	}
+	return sums, changed, inertia
+}
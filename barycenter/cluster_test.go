@@ -0,0 +1,107 @@
+package barycenter
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// checkClustering checks that every point is in the cluster with the nearest barycenter,
+// and that each cluster's barycenter is Linear's for its points.
+func checkClustering(t *testing.T, what string, points []MassPoint, c Clustering) {
+	t.Helper()
+	members := make([][]MassPoint, len(c.Clusters))
+	for i, p := range points {
+		j := c.Assignments[i]
+		members[j] = append(members[j], p)
+		if d := distance2(p, c.Clusters[j].Barycenter); c.Converged {
+			for k, other := range c.Clusters {
+				if distance2(p, other.Barycenter) < d {
+					t.Fatalf("%s: point %d is in cluster %d, but cluster %d is closer", what, i, j, k)
+				}
+			}
+		}
+	}
+	for j, cl := range c.Clusters {
+		want, err := Linear{}.Compute(members[j])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cl.Bodies != len(members[j]) || !closeToPoint(cl.Barycenter, want) {
+			t.Errorf("%s: cluster %d has %v from %d bodies, want %v from %d",
+				what, j, cl.Barycenter, cl.Bodies, want, len(members[j]))
+		}
+	}
+}
+
+// The chunks of work, and the order their results are combined in, don't depend on the
+// number of workers, so neither should the clusters, down to the last bit.
+func TestKMeansSameForAnyWorkers(t *testing.T) {
+	points := RandomMassPoints(3*clusterChunk+5, 1)
+	o := ClusterOptions{K: 8, Seed: 7}
+	o.Workers = 1
+	want, err := KMeans(points, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.Clusters) != 8 || !want.Converged {
+		t.Fatalf("got %d clusters, converged %v, want 8 that converged", len(want.Clusters), want.Converged)
+	}
+	checkClustering(t, "1 worker", points, want)
+	for _, workers := range []int{2, 3, 8, 64} {
+		o.Workers = workers
+		got, err := KMeans(points, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: got a different clustering from 1 worker", workers)
+		}
+	}
+}
+
+// Asking for as many clusters as points, or more, gives each distinct position a cluster
+// of its own, and bodies on top of each other always share one.
+func TestKMeansFewPositions(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		points []MassPoint
+		k      int
+		want   int
+	}{
+		{"k equals points", []MassPoint{{0, 0, 0, 1}, {10, 0, 0, 2}, {0, 10, 0, 3}}, 3, 3},
+		{"k over points", []MassPoint{{0, 0, 0, 1}, {10, 0, 0, 2}, {0, 10, 0, 3}}, 10, 3},
+		{"duplicates", []MassPoint{
+			{1, 1, 1, 1}, {1, 1, 1, 2}, {5, 5, 5, 1}, {1, 1, 1, 1}, {5, 5, 5, 3}, {9, 0, 0, 1},
+		}, 5, 3},
+		{"all in one place", []MassPoint{{2, 2, 2, 1}, {2, 2, 2, 1}, {2, 2, 2, 1}}, 4, 1},
+	} {
+		for _, workers := range []int{1, 4} {
+			c, err := KMeans(tc.points, ClusterOptions{K: tc.k, Seed: 1, Workers: workers})
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if len(c.Clusters) != tc.want || !c.Converged || c.Inertia != 0 {
+				t.Errorf("%s, %d workers: got %d clusters, converged %v, inertia %g, want %d, true, 0",
+					tc.name, workers, len(c.Clusters), c.Converged, c.Inertia, tc.want)
+			}
+			checkClustering(t, tc.name, tc.points, c)
+		}
+	}
+}
+
+func TestKMeansErrors(t *testing.T) {
+	if _, err := KMeans(nil, ClusterOptions{K: 1}); !errors.Is(err, ErrNoPoints) {
+		t.Errorf("no points: got error %v, want ErrNoPoints", err)
+	}
+	if _, err := KMeans([]MassPoint{{1, 2, 3, 4}}, ClusterOptions{K: 0}); !errors.Is(err, ErrTooFewClusters) {
+		t.Errorf("no clusters: got error %v, want ErrTooFewClusters", err)
+	}
+	if _, err := KMeans([]MassPoint{{1, 2, 3, 0}, {4, 5, 6, -1}}, ClusterOptions{K: 2}); !errors.Is(err, ErrZeroMass) {
+		t.Errorf("no positive mass: got error %v, want ErrZeroMass", err)
+	}
+	if _, err := KMeans([]MassPoint{{math.Inf(1), 2, 3, 4}}, ClusterOptions{K: 1}); !errors.Is(err, ErrNotFinite) {
+		t.Errorf("infinite position: got error %v, want ErrNotFinite", err)
+	}
+}
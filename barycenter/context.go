@@ -0,0 +1,101 @@
+package barycenter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+)
+
+// checkEvery is how many lines, records or points the loaders and strategies get through
+// between checks of their context, and updates of their progress. Checking is cheap, but
+// not so cheap that it's worth doing for every point.
+const checkEvery = 1 << 12
+
+// Progress counts how far a load has got. Loaders update it from however many goroutines
+// they use, so it can be read from another goroutine to report on a long run.
+// The zero Progress is ready to use.
+type Progress struct {
+	bytes, lines, total int64
+}
+
+// Bytes returns the number of bytes of input read so far.
+func (p *Progress) Bytes() int64 { return atomic.LoadInt64(&p.bytes) }
+
+// Lines returns the number of lines read so far, or records in the binary format.
+func (p *Progress) Lines() int64 { return atomic.LoadInt64(&p.lines) }
+
+// Total returns the size of the input in bytes, or zero if it isn't known, as for
+// standard input and compressed files.
+func (p *Progress) Total() int64 { return atomic.LoadInt64(&p.total) }
+
+func (p *Progress) add(bytes, lines int64) {
+	if p != nil {
+		atomic.AddInt64(&p.bytes, bytes)
+		atomic.AddInt64(&p.lines, lines)
+	}
+}
+
+// setTotal sets the size of the input, unless it's already been set. When several files
+// are loaded, the total is set to their combined size before any of them is opened.
+func (p *Progress) setTotal(size int64) {
+	if p != nil && size > 0 {
+		atomic.CompareAndSwapInt64(&p.total, 0, size)
+	}
+}
+
+// A trackingReader counts what's read through it into a Progress, and stops reading once
+// its context is done.
+type trackingReader struct {
+	r        io.Reader
+	ctx      context.Context
+	progress *Progress
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	if t.ctx != nil {
+		if err := t.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := t.r.Read(p)
+	t.progress.add(int64(n), int64(bytes.Count(p[:n], []byte{'\n'})))
+	return n, err
+}
+
+// track wraps r so that reading it follows opts.Context and counts into opts.Progress.
+// The options it returns have neither, so nothing further down counts the same bytes twice.
+func (opts LoadOptions) track(r io.Reader) (io.Reader, LoadOptions) {
+	if opts.Context == nil && opts.Progress == nil {
+		return r, opts
+	}
+	r = &trackingReader{r: r, ctx: opts.Context, progress: opts.Progress}
+	opts.Context, opts.Progress = nil, nil
+	return r, opts
+}
+
+// stopped returns the error of opts.Context, if it's done.
+func (opts LoadOptions) stopped() error {
+	if opts.Context == nil {
+		return nil
+	}
+	return opts.Context.Err()
+}
+
+// A contextStrategy is a Strategy that can stop partway through.
+type contextStrategy interface {
+	computeContext(ctx context.Context, points []MassPoint) (MassPoint, error)
+}
+
+// ComputeContext is s.Compute(points), except that it gives up once ctx is done, and
+// returns ctx's error. The Chunked, CompensatedSum and ExactSum strategies stop their
+// workers partway through; the others can only be stopped before they start.
+func ComputeContext(ctx context.Context, s Strategy, points []MassPoint) (MassPoint, error) {
+	if err := ctx.Err(); err != nil {
+		return MassPoint{}, err
+	}
+	if cs, ok := s.(contextStrategy); ok {
+		return cs.computeContext(ctx, points)
+	}
+	return s.Compute(points)
+}
@@ -0,0 +1,475 @@
+package barycenter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Decoder reads body records in one input format, handing each point to fn in order.
+// Malformed records are handled according to opts, just like malformed text lines.
+type Decoder interface {
+	Decode(r io.Reader, opts LoadOptions, fn func(MassPoint)) (Rejects, error)
+}
+
+// TextDecoder reads the text format written by genBodies, and the binary format,
+// which it tells apart by the binary format's magic bytes. It's the default decoder,
+// and the only one the concurrent loaders can split between workers.
+type TextDecoder struct{}
+
+// Decode implements Decoder.
+func (TextDecoder) Decode(r io.Reader, opts LoadOptions, fn func(MassPoint)) (Rejects, error) {
+	opts.Decoder = nil
+	return scanLines(r, opts, funcSink(fn))
+}
+
+// A BodyDecoder is a Decoder that can hand over whole bodies, labels and all,
+// rather than just their mass points.
+type BodyDecoder interface {
+	Decoder
+	DecodeBodies(r io.Reader, opts LoadOptions, fn func(Body)) (Rejects, error)
+}
+
+// decodeInto reads r with dec into s, keeping whole bodies if dec can hand them over.
+func decodeInto(dec Decoder, r io.Reader, opts LoadOptions, s sink) (Rejects, error) {
+	if bd, ok := dec.(BodyDecoder); ok {
+		return bd.DecodeBodies(r, opts, s.addBody)
+	}
+	return dec.Decode(r, opts, func(p MassPoint) { s.addBody(Body{MassPoint: p}) })
+}
+
+// customDecoder returns the decoder set in opts, or nil if the built-in loaders can
+// handle the format themselves.
+func customDecoder(opts LoadOptions) Decoder {
+	switch opts.Decoder.(type) {
+	case nil, TextDecoder, *TextDecoder:
+		return nil
+	}
+	return opts.Decoder
+}
+
+// A ColumnMap says which columns (or JSON fields) hold a body's coordinates and mass,
+// and its label. Each entry is a column name from the header, or a 1-based column number.
+// Unlike the others, the label column is optional: if there's no such column, the bodies
+// have no labels.
+type ColumnMap struct {
+	X, Y, Z, Mass string
+	Label         string
+}
+
+// DefaultColumns is the column map used when none is given.
+var DefaultColumns = ColumnMap{"x", "y", "z", "mass", "label"}
+
+// ParseColumnMap parses a column map like "x=lon,y=lat,z=alt,mass=3,label=cluster".
+// Fields that aren't mentioned keep their default names.
+func ParseColumnMap(s string) (ColumnMap, error) {
+	cols := DefaultColumns
+	if s == "" {
+		return cols, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ColumnMap{}, fmt.Errorf("barycenter: bad column mapping %q", pair)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "x":
+			cols.X = kv[1]
+		case "y":
+			cols.Y = kv[1]
+		case "z":
+			cols.Z = kv[1]
+		case "mass", "m":
+			cols.Mass = kv[1]
+		case "label":
+			cols.Label = kv[1]
+		default:
+			return ColumnMap{}, fmt.Errorf("barycenter: unknown field %q in column mapping", kv[0])
+		}
+	}
+	return cols, nil
+}
+
+// positional is the column map for a CSV file with no header to look names up in. Fields
+// left at their default names are read from where the text format has them: x, y, z and
+// mass in the first four columns, and the label in the fifth.
+func (c ColumnMap) positional() ColumnMap {
+	if c == (ColumnMap{}) {
+		c = DefaultColumns
+	}
+	fields := [5]*string{&c.X, &c.Y, &c.Z, &c.Mass, &c.Label}
+	defaults := [5]string{DefaultColumns.X, DefaultColumns.Y, DefaultColumns.Z, DefaultColumns.Mass, DefaultColumns.Label}
+	for i, f := range fields {
+		if *f == defaults[i] {
+			*f = strconv.Itoa(i + 1)
+		}
+	}
+	return c
+}
+
+func (c ColumnMap) names() [4]string {
+	if c == (ColumnMap{}) {
+		c = DefaultColumns
+	}
+	return [4]string{c.X, c.Y, c.Z, c.Mass}
+}
+
+func (c ColumnMap) label() string {
+	if c == (ColumnMap{}) {
+		c = DefaultColumns
+	}
+	return c.Label
+}
+
+// CSVDecoder reads bodies from CSV, one row per body.
+type CSVDecoder struct {
+	// Columns says which columns to read. Names are looked up in the header row;
+	// numbers work with or without one.
+	Columns ColumnMap
+	// NoHeader says the first row is a body rather than column names.
+	NoHeader bool
+	// Comma is the field delimiter. If it's zero, a comma is used.
+	Comma rune
+}
+
+// Decode implements Decoder.
+func (d CSVDecoder) Decode(r io.Reader, opts LoadOptions, fn func(MassPoint)) (Rejects, error) {
+	return d.DecodeBodies(r, opts, func(b Body) { fn(b.MassPoint) })
+}
+
+// DecodeBodies implements BodyDecoder.
+func (d CSVDecoder) DecodeBodies(r io.Reader, opts LoadOptions, fn func(Body)) (Rejects, error) {
+	cr := csv.NewReader(r)
+	if d.Comma != 0 {
+		cr.Comma = d.Comma
+	}
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	var header []string
+	if !d.NoHeader {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return Rejects{}, nil
+		} else if err != nil {
+			return Rejects{}, err
+		}
+		header = append([]string(nil), row...)
+	}
+	index, err := columnIndex(d.Columns.names(), header)
+	if err != nil {
+		return Rejects{}, fmt.Errorf("%s: %v", opts.Name, err)
+	}
+	label := labelIndex(d.Columns.label(), header)
+
+	var rejects Rejects
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return rejects, nil
+		}
+
+		var perr *ParseError
+		var b Body
+		if cerr, ok := err.(*csv.ParseError); ok {
+			perr = &ParseError{Line: cerr.Line, Column: cerr.Column, Err: cerr.Err}
+		} else if err != nil {
+			return Rejects{}, err
+		} else {
+			b, perr = csvRecord(cr, row, index, label, opts.Validation)
+		}
+		if perr != nil {
+			perr.Name = opts.Name
+			if err := rejects.note(perr, opts); err != nil {
+				return Rejects{}, err
+			}
+			if !perr.flagged {
+				continue
+			}
+		}
+		fn(b)
+	}
+}
+
+// csvRecord picks a body out of a CSV row, and checks its values against policy.
+// label is the label's column, or -1 if there isn't one.
+func csvRecord(cr *csv.Reader, row []string, index [4]int, label int, policy Validation) (Body, *ParseError) {
+	var vals [4]float64
+	for i, at := range index {
+		if at >= len(row) {
+			line, _ := cr.FieldPos(0)
+			return Body{}, &ParseError{Line: line, Column: 1, Text: strings.Join(row, ","),
+				Err: fmt.Errorf("missing %s column", fieldNames[i])}
+		}
+		v, err := parseFloat([]byte(strings.TrimSpace(row[at])))
+		if err != nil {
+			line, col := cr.FieldPos(at)
+			return Body{}, &ParseError{Line: line, Column: col, Text: strings.Join(row, ","),
+				Err: fmt.Errorf("invalid %s value %q", fieldNames[i], row[at])}
+		}
+		vals[i] = v
+	}
+	b := Body{MassPoint: MassPoint{vals[0], vals[1], vals[2], vals[3]}}
+	if label >= 0 && label < len(row) {
+		b.Label = strings.TrimSpace(row[label])
+	}
+	field, perr := checkBody(b, policy)
+	if perr != nil {
+		perr.Line, perr.Column = cr.FieldPos(index[field])
+		perr.Text = strings.Join(row, ",")
+	}
+	return b, perr
+}
+
+// labelIndex finds the 0-based column for the label, like columnIndex does for the other
+// fields, or returns -1 if there's no such column.
+func labelIndex(name string, header []string) int {
+	if name == "" {
+		return -1
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		return n - 1
+	}
+	for j, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return j
+		}
+	}
+	return -1
+}
+
+// columnIndex finds the 0-based column for each name, either by number or in the header.
+func columnIndex(names [4]string, header []string) ([4]int, error) {
+	var index [4]int
+	for i, name := range names {
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 {
+				return index, fmt.Errorf("column number %d for %s must be at least 1", n, fieldNames[i])
+			}
+			index[i] = n - 1
+			continue
+		}
+		index[i] = -1
+		for j, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				index[i] = j
+				break
+			}
+		}
+		if index[i] < 0 {
+			return index, fmt.Errorf("no column named %q for %s", name, fieldNames[i])
+		}
+	}
+	return index, nil
+}
+
+// NDJSONDecoder reads bodies from newline-delimited JSON, one object per line.
+// Values may be JSON numbers or strings holding numbers.
+type NDJSONDecoder struct {
+	// Columns names the object fields to read. Column numbers don't mean anything here.
+	Columns ColumnMap
+}
+
+// Decode implements Decoder.
+func (d NDJSONDecoder) Decode(r io.Reader, opts LoadOptions, fn func(MassPoint)) (Rejects, error) {
+	return d.DecodeBodies(r, opts, func(b Body) { fn(b.MassPoint) })
+}
+
+// DecodeBodies implements BodyDecoder.
+func (d NDJSONDecoder) DecodeBodies(r io.Reader, opts LoadOptions, fn func(Body)) (Rejects, error) {
+	names := d.Columns.names()
+	label := d.Columns.label()
+	br := bufio.NewReader(r)
+	var long []byte
+	var rejects Rejects
+	for lineNo := 1; ; lineNo++ {
+		line, err := readLine(br, &long)
+		if len(bytes.TrimSpace(line)) > 0 {
+			b, perr := ndjsonRecord(line, names, label, opts.Validation)
+			if perr != nil {
+				perr.Name = opts.Name
+				perr.Line = lineNo
+				if err := rejects.note(perr, opts); err != nil {
+					return Rejects{}, err
+				}
+			}
+			if perr == nil || perr.flagged {
+				fn(b)
+			}
+		}
+		if err == io.EOF {
+			return rejects, nil
+		} else if err != nil {
+			return Rejects{}, err
+		}
+	}
+}
+
+// ndjsonRecord picks a body out of one JSON object, and checks its values against policy.
+// The label field is optional, and may be a string or a number.
+func ndjsonRecord(line []byte, names [4]string, label string, policy Validation) (Body, *ParseError) {
+	text := string(bytes.TrimRight(line, "\r\n"))
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(line, &obj); err != nil {
+		col := 1
+		if serr, ok := err.(*json.SyntaxError); ok {
+			col = int(serr.Offset)
+		}
+		return Body{}, &ParseError{Column: col, Text: text, Err: err}
+	}
+
+	var vals [4]float64
+	for i, name := range names {
+		raw, ok := obj[name]
+		if !ok {
+			return Body{}, &ParseError{Column: 1, Text: text,
+				Err: fmt.Errorf("missing %s field %q", fieldNames[i], name)}
+		}
+		raw = bytes.Trim(raw, `"`)
+		v, err := parseFloat(raw)
+		if err != nil {
+			return Body{}, &ParseError{Column: keyColumn(line, name), Text: text,
+				Err: fmt.Errorf("invalid %s value %s", fieldNames[i], raw)}
+		}
+		vals[i] = v
+	}
+	b := Body{MassPoint: MassPoint{vals[0], vals[1], vals[2], vals[3]}}
+	if raw, ok := obj[label]; ok && label != "" {
+		var s string
+		if json.Unmarshal(raw, &s) != nil {
+			s = string(raw)
+		}
+		b.Label = strings.TrimSpace(s)
+	}
+	field, perr := checkBody(b, policy)
+	if perr != nil {
+		perr.Column = keyColumn(line, names[field])
+		perr.Text = text
+	}
+	return b, perr
+}
+
+// keyColumn finds the column where an NDJSON line names the key. If the key is written
+// some other way, like with escapes, a plain search won't find it, so we settle for column 1.
+func keyColumn(line []byte, key string) int {
+	if i := bytes.Index(line, []byte(strconv.Quote(key))); i >= 0 {
+		return i + 1
+	}
+	return 1
+}
+
+// DecoderByName looks up a decoder by format name: text, binary, csv, csv-noheader or
+// ndjson (or its other name, jsonl). The column map is used by the csv and ndjson decoders.
+// csv-noheader reads CSV whose first row is already a body, so its columns go by number;
+// any not given are where the text format has them.
+func DecoderByName(name string, cols ColumnMap) (Decoder, error) {
+	switch name {
+	case "text", "binary":
+		return TextDecoder{}, nil
+	case "csv":
+		return CSVDecoder{Columns: cols}, nil
+	case "csv-noheader":
+		return CSVDecoder{Columns: cols.positional(), NoHeader: true}, nil
+	case "ndjson", "jsonl":
+		return NDJSONDecoder{Columns: cols}, nil
+	}
+	return nil, fmt.Errorf("barycenter: unknown input format %q", name)
+}
+
+// DecoderForPath picks a decoder by file extension, looking past a .gz extension.
+// .csv files get the CSV decoder, .ndjson and .jsonl files the NDJSON decoder,
+// and anything else the text decoder.
+func DecoderForPath(path string, cols ColumnMap) Decoder {
+	path = strings.TrimSuffix(strings.ToLower(path), ".gz")
+	switch filepath.Ext(path) {
+	case ".csv":
+		return CSVDecoder{Columns: cols}
+	case ".ndjson", ".jsonl":
+		return NDJSONDecoder{Columns: cols}
+	}
+	return TextDecoder{}
+}
+
+// gzipMagic starts every gzip stream.
+const gzipMagic = "\x1f\x8b"
+
+// An input is an opened body file, decompressed if need be.
+type input struct {
+	io.Reader
+	file *os.File
+	gz   *gzip.Reader
+	// size is the length of the file, or -1 if it can't be read at random,
+	// like a pipe or compressed data.
+	size int64
+	// mapped is the file mapped into memory, once ranges has mapped it.
+	mapped mappedFile
+}
+
+// Close closes the decompressor, if there is one, and the file, unless it's standard input.
+// If the file was mapped into memory, the mapping goes too.
+func (in *input) Close() error {
+	var err error
+	if in.gz != nil {
+		err = in.gz.Close()
+	}
+	if in.mapped != nil {
+		if uerr := unmapFile(in.mapped); err == nil {
+			err = uerr
+		}
+		in.mapped = nil
+	}
+	if in.file != os.Stdin {
+		if cerr := in.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openInput opens the body file called name. "-" is standard input, and gzip-compressed
+// data is spotted by its magic bytes and decompressed on the way in.
+func openInput(name string) (*input, error) {
+	in := &input{file: os.Stdin, size: -1}
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		in.file = f
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			in.size = fi.Size()
+		}
+	}
+
+	br := bufio.NewReader(in.file)
+	in.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); string(magic) == gzipMagic {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			in.Close()
+			return nil, err
+		}
+		in.gz = gz
+		in.Reader = gz
+		in.size = -1
+	}
+	return in, nil
+}
+
+// OpenInput opens the body file called name for reading. "-" is standard input,
+// and gzip-compressed files are decompressed on the way in.
+func OpenInput(name string) (io.ReadCloser, error) {
+	in, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
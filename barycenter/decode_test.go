@@ -0,0 +1,70 @@
+package barycenter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONErrorColumns(t *testing.T) {
+	names := [4]string{"x", "y", "z", "mass"}
+	for _, tc := range []struct {
+		line string
+		want int
+	}{
+		{`{"x":"oops","y":1,"z":1,"mass":1}`, 2},
+		{`{"x":1,"y":1,"z":1,"mass":-1}`, 20},
+		// Keys written with escapes can't be found by searching for them.
+		{`{"\u0078":"oops","y":1,"z":1,"mass":1}`, 1},
+		{`{"x":1,"y":1,"z":1,"m\u0061ss":-1}`, 1},
+		{`{"y":1,"z":1,"mass":1}`, 1},
+	} {
+		_, perr := ndjsonRecord([]byte(tc.line), names, "", RejectInvalid)
+		if perr == nil {
+			t.Errorf("%s: no error", tc.line)
+		} else if perr.Column != tc.want {
+			t.Errorf("%s: error %v is in column %d, want %d", tc.line, perr, perr.Column, tc.want)
+		}
+	}
+}
+
+// csv-noheader reads the first row as a body, with the columns where the text format has
+// them unless they're given by number.
+func TestCSVNoHeader(t *testing.T) {
+	for _, tc := range []struct {
+		columns, input string
+		want           []Body
+	}{
+		{"", "1,2,3,4\n5,6,7,8,heavy\n", []Body{{MassPoint: MassPoint{1, 2, 3, 4}}, {MassPoint: MassPoint{5, 6, 7, 8}, Label: "heavy"}}},
+		{"mass=1,x=4,label=6", "4,2,3,1,no,a\n", []Body{{MassPoint: MassPoint{1, 2, 3, 4}, Label: "a"}}},
+	} {
+		cols, err := ParseColumnMap(tc.columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := DecoderByName("csv-noheader", cols)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Body
+		rejects, err := d.(BodyDecoder).DecodeBodies(strings.NewReader(tc.input), LoadOptions{Strict: true}, func(b Body) {
+			got = append(got, b)
+		})
+		if err != nil || rejects.Count != 0 {
+			t.Fatalf("columns %q: got error %v and %d rejects", tc.columns, err, rejects.Count)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("columns %q: got %v, want %v", tc.columns, got, tc.want)
+		}
+	}
+
+	// With a header, the plain csv decoder would have skipped the first row; this one
+	// rejects it instead.
+	d, _ := DecoderByName("csv-noheader", DefaultColumns)
+	_, err := d.Decode(strings.NewReader("x,y,z,mass\n1,2,3,4\n"), LoadOptions{Strict: true}, func(MassPoint) {})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 1 {
+		t.Errorf("got error %v, want one on line 1", err)
+	}
+}
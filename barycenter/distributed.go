@@ -0,0 +1,588 @@
+package barycenter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Barycenters can be worked out across several processes, or machines, as well as several
+// goroutines. A Coordinator splits body files into shards and sends each one to a worker
+// over HTTP. The worker folds the shard into a WeightedSum, just like StreamConcurrent,
+// and sends the partial sum back, and the coordinator merges the partial sums in order.
+//
+// The protocol is two requests:
+//
+//	POST /shard?name=...&strict=...&examples=...&invalid=...
+//	     The body is the shard itself, in the text or binary format. The reply is a
+//	     shardReply in JSON; a strict mode failure is a 422 with the error in the reply.
+//	GET  /health
+//	     200 if the worker is up.
+//
+// The shards are sent over the wire, so the workers don't need to see the coordinator's files.
+
+// DefaultShardSize is the size of the shards a Coordinator sends, if it isn't told otherwise.
+const DefaultShardSize = 16 << 20
+
+// shardReply is a worker's answer to a shard.
+type shardReply struct {
+	Sum wireSum `json:"sum"`
+	// Lines is the number of lines in the shard, for renumbering diagnostics.
+	Lines   int         `json:"lines"`
+	Rejects wireRejects `json:"rejects"`
+	// Error is the strict mode failure, if there was one.
+	Error *wireError `json:"error,omitempty"`
+}
+
+// wireSum is a WeightedSum on the wire, compensation terms and all, so merging partial
+// sums from other processes is as accurate as merging them from other goroutines.
+type wireSum struct {
+	X, Y, Z, Mass  float64
+	Count          int
+	CX, CY, CZ, CM float64
+	AX, AY, AZ, AM float64
+}
+
+func toWire(s WeightedSum) wireSum {
+	return wireSum{s.X, s.Y, s.Z, s.Mass, s.Count, s.cx, s.cy, s.cz, s.cm, s.ax, s.ay, s.az, s.am}
+}
+
+func (w wireSum) sum() WeightedSum {
+	return WeightedSum{X: w.X, Y: w.Y, Z: w.Z, Mass: w.Mass, Count: w.Count,
+		cx: w.CX, cy: w.CY, cz: w.CZ, cm: w.CM, ax: w.AX, ay: w.AY, az: w.AZ, am: w.AM}
+}
+
+type wireRejects struct {
+	Count           int         `json:"count"`
+	Examples        []wireError `json:"examples,omitempty"`
+	Flagged         int         `json:"flagged"`
+	FlaggedExamples []wireError `json:"flagged_examples,omitempty"`
+}
+
+type wireError struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Text   string `json:"text"`
+	Error  string `json:"error"`
+}
+
+func toWireError(e *ParseError) wireError {
+	return wireError{e.Line, e.Column, e.Text, e.Err.Error()}
+}
+
+func (w wireError) parseError(name string, flagged bool) *ParseError {
+	return &ParseError{Name: name, Line: w.Line, Column: w.Column, Text: w.Text,
+		Err: errors.New(w.Error), flagged: flagged}
+}
+
+func toWireRejects(r Rejects) wireRejects {
+	out := wireRejects{Count: r.Count, Flagged: r.Flagged}
+	for _, e := range r.Examples {
+		out.Examples = append(out.Examples, toWireError(e))
+	}
+	for _, e := range r.FlaggedExamples {
+		out.FlaggedExamples = append(out.FlaggedExamples, toWireError(e))
+	}
+	return out
+}
+
+func (w wireRejects) rejects(name string) Rejects {
+	r := Rejects{Count: w.Count, Flagged: w.Flagged}
+	for _, e := range w.Examples {
+		r.Examples = append(r.Examples, e.parseError(name, false))
+	}
+	for _, e := range w.FlaggedExamples {
+		r.FlaggedExamples = append(r.FlaggedExamples, e.parseError(name, true))
+	}
+	return r
+}
+
+// lineCounter counts the lines read through it, the way scanLines numbers them.
+type lineCounter struct {
+	r     io.Reader
+	lines int
+	last  byte
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.lines += bytes.Count(p[:n], []byte{'\n'})
+		c.last = p[n-1]
+	}
+	return n, err
+}
+
+// total is the number of lines, counting a last line without a newline.
+func (c *lineCounter) total() int {
+	if c.last != 0 && c.last != '\n' {
+		return c.lines + 1
+	}
+	return c.lines
+}
+
+// NewShardHandler returns the worker side of the protocol, which folds each shard it's
+// sent into a WeightedSum with workers goroutines. If workers is zero or less,
+// GOMAXPROCS is used.
+func NewShardHandler(workers int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/shard", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "shards must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		opts := LoadOptions{Name: q.Get("name"), Workers: workers, Strict: q.Get("strict") == "true"}
+		opts.MaxExamples, _ = strconv.Atoi(q.Get("examples"))
+		var err error
+		if opts.Validation, err = ParseValidation(q.Get("invalid")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body := &lineCounter{r: r.Body}
+		sum, rejects, err := StreamConcurrent(body, opts)
+		reply := shardReply{Sum: toWire(sum), Lines: body.total(), Rejects: toWireRejects(rejects)}
+		status := http.StatusOK
+		if perr, ok := err.(*ParseError); ok {
+			e := toWireError(perr)
+			reply.Error = &e
+			status = http.StatusUnprocessableEntity
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(reply)
+	})
+	return mux
+}
+
+// A Coordinator shards body files between workers serving NewShardHandler.
+// When a worker fails or times out on a shard, the shard goes back in the queue for
+// another worker, and the failed worker is left alone until it answers a health check.
+// A 400 is different: it means the worker couldn't make sense of the shard or the
+// options it was sent with, and any other worker would say the same, so Run gives up
+// straight away rather than trying the shard again.
+type Coordinator struct {
+	// Peers are the workers' addresses, as host:port or as URLs.
+	Peers []string
+	// Client makes the requests. If it's nil, http.DefaultClient is used.
+	Client *http.Client
+	// ShardSize is roughly how many bytes to send in each shard. If it's zero or less,
+	// DefaultShardSize is used.
+	ShardSize int64
+	// ShardTimeout is how long a worker gets to answer a shard before it's taken to have
+	// failed. If it's zero or less, there's no limit.
+	ShardTimeout time.Duration
+	// MaxAttempts is how many times a shard is tried before giving up. If it's zero or
+	// less, each shard gets three tries.
+	MaxAttempts int
+	// RetryInterval is how often failed workers are checked on. If it's zero or less,
+	// it's a second.
+	RetryInterval time.Duration
+	// GiveUpAfter is how long a worker may fail its health checks before the coordinator
+	// stops waiting for it. If it's zero or less, it's a minute.
+	GiveUpAfter time.Duration
+	// Log, if it's not nil, is told about workers failing and coming back.
+	Log io.Writer
+}
+
+// ErrNoWorkers is returned when every worker has failed, with shards still to be done.
+var ErrNoWorkers = errors.New("barycenter: every worker has failed")
+
+// A shard is one piece of a file, as sent to a worker.
+type shard struct {
+	file       int
+	name       string
+	start, end int64
+	// header is sent before binary shards, so each one is a binary file of its own.
+	header []byte
+	// firstRecord is the number of binary records before the shard in its file.
+	firstRecord int64
+}
+
+// A shardResult is what came back for a shard.
+type shardResult struct {
+	sum     WeightedSum
+	rejects Rejects
+	lines   int
+	err     *ParseError
+	skipped bool
+}
+
+// Run works out the barycenter of the bodies in the named files, split between the peers.
+// opts supplies the strictness, examples and validation policy; the files must be
+// uncompressed text or binary body files, since other inputs can't be split up.
+func (c *Coordinator) Run(names []string, opts LoadOptions) (WeightedSum, Rejects, error) {
+	if len(c.Peers) == 0 {
+		return WeightedSum{}, Rejects{}, ErrNoWorkers
+	}
+	var shards []shard
+	files := make([]*os.File, len(names))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, name := range names {
+		in, err := openInput(name)
+		if err != nil {
+			return WeightedSum{}, Rejects{}, err
+		}
+		if in.size < 0 {
+			in.Close()
+			return WeightedSum{}, Rejects{}, fmt.Errorf("%s: can't be split between workers; it has to be an uncompressed file", name)
+		}
+		files[i] = in.file
+		planned, err := c.planShards(in.file, in.size, i, name)
+		if err != nil {
+			return WeightedSum{}, Rejects{}, err
+		}
+		shards = append(shards, planned...)
+	}
+
+	results, err := c.dispatch(shards, files, opts)
+	if err != nil {
+		return WeightedSum{}, Rejects{}, err
+	}
+
+	// Merge the partial sums in order, and renumber the diagnostics from the start of each file.
+	var sum WeightedSum
+	var rejects Rejects
+	offset := 0
+	for i, res := range results {
+		if i > 0 && shards[i].file != shards[i-1].file {
+			offset = 0
+		}
+		if shards[i].header != nil {
+			offset = int(shards[i].firstRecord)
+		}
+		if res.err != nil {
+			res.err.Line += offset
+			return WeightedSum{}, Rejects{}, res.err
+		}
+		res.rejects.shift(offset)
+		offset += res.lines
+		sum.Merge(res.sum)
+		rejects.merge(res.rejects, opts.MaxExamples)
+	}
+	return sum, rejects, nil
+}
+
+// planShards splits size bytes of r, file number file, into shards. Text shards start at
+// the start of a line, and binary shards at the start of a record.
+func (c *Coordinator) planShards(r *os.File, size int64, file int, name string) ([]shard, error) {
+	shardSize := c.ShardSize
+	if shardSize <= 0 {
+		shardSize = DefaultShardSize
+	}
+
+	if isBinaryAt(r) {
+		h, err := readBinaryHeader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		recSize := h.recordSize()
+		count, ok := h.records(size)
+		if !ok {
+			return nil, errTruncated(name)
+		}
+		perShard := shardSize/recSize + 1
+		var shards []shard
+		for first := int64(0); first < count || first == 0; first += perShard {
+			n := count - first
+			if n > perShard {
+				n = perShard
+			}
+			shards = append(shards, shard{
+				file: file, name: name,
+				start: h.size + first*recSize, end: h.size + (first+n)*recSize,
+				header: encodeBinaryHeader(h.fields, n), firstRecord: first,
+			})
+		}
+		return shards, nil
+	}
+
+	// Even an empty file gets a shard, so it's counted like any other.
+	var shards []shard
+	for start := int64(0); ; {
+		end, err := nextLineStart(r, start+shardSize, size)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard{file: file, name: name, start: start, end: end})
+		if end >= size {
+			return shards, nil
+		}
+		start = end
+	}
+}
+
+// nextLineStart finds the first line that starts at or after pos.
+func nextLineStart(r io.ReaderAt, pos, size int64) (int64, error) {
+	if pos >= size {
+		return size, nil
+	}
+	buf := make([]byte, 4096)
+	for at := pos - 1; at < size; at += int64(len(buf)) {
+		n, err := r.ReadAt(buf, at)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return at + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// A peerFailure is a worker failing to handle a shard, rather than a problem with the shard.
+type peerFailure struct{ err error }
+
+func (f peerFailure) Error() string { return f.err.Error() }
+
+// dispatch hands the shards out to the peers, one goroutine per peer, and collects the results.
+func (c *Coordinator) dispatch(shards []shard, files []*os.File, opts LoadOptions) ([]shardResult, error) {
+	maxAttempts := c.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make([]shardResult, len(shards))
+	attempts := make([]int, len(shards))
+	// todo holds the shards waiting for a worker. It's big enough for every shard, and a
+	// shard is only ever in it once, so sends never block.
+	todo := make(chan int, len(shards))
+	for i := range shards {
+		todo <- i
+	}
+	var mu sync.Mutex
+	remaining := len(shards)
+	done := make(chan struct{})
+	var fatal error
+	fail := func(err error) {
+		if fatal == nil {
+			fatal = err
+			close(done)
+		}
+	}
+	// failed is the index of the first shard to hit a strict mode failure, as in scanRanges.
+	failed := int64(len(shards))
+
+	var wg sync.WaitGroup
+	for _, peer := range c.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			for {
+				var i int
+				select {
+				case i = <-todo:
+				case <-done:
+					return
+				}
+
+				var res shardResult
+				var err error
+				mu.Lock()
+				skip := int64(i) > failed
+				mu.Unlock()
+				if skip {
+					res.skipped = true
+				} else {
+					res, err = c.send(ctx, peer, shards[i], files[shards[i].file], opts)
+				}
+
+				mu.Lock()
+				if f, ok := err.(peerFailure); ok {
+					attempts[i]++
+					c.logf("worker %s failed on %s shard %d: %v\n", peer, shards[i].name, i+1, f.err)
+					if attempts[i] >= maxAttempts {
+						fail(fmt.Errorf("%s: shard %d failed %d times, last with: %v", shards[i].name, i+1, attempts[i], f.err))
+						mu.Unlock()
+						return
+					}
+					todo <- i
+					mu.Unlock()
+					if !c.waitForPeer(ctx, peer, done) {
+						return
+					}
+					continue
+				}
+				if err != nil {
+					fail(err)
+					mu.Unlock()
+					return
+				}
+				if res.err != nil && int64(i) < failed {
+					failed = int64(i)
+				}
+				results[i] = res
+				remaining--
+				if remaining == 0 && fatal == nil {
+					close(done)
+				}
+				mu.Unlock()
+			}
+		}(peer)
+	}
+
+	// If every peer gives up, nobody is left to close done.
+	gone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(gone)
+	}()
+	select {
+	case <-done:
+	case <-gone:
+	}
+	cancel()
+	<-gone
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fatal != nil {
+		return nil, fatal
+	}
+	if remaining > 0 {
+		return nil, ErrNoWorkers
+	}
+	return results, nil
+}
+
+// waitForPeer checks on a failed peer until it answers a health check, and reports
+// whether it did. It gives up after GiveUpAfter, or once there's nothing left to do.
+func (c *Coordinator) waitForPeer(ctx context.Context, peer string, done <-chan struct{}) bool {
+	interval := c.RetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	giveUp := c.GiveUpAfter
+	if giveUp <= 0 {
+		giveUp = time.Minute
+	}
+	deadline := time.Now().Add(giveUp)
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			return false
+		case <-time.After(interval):
+		}
+		if c.healthy(ctx, peer, interval) {
+			c.logf("worker %s is back\n", peer)
+			return true
+		}
+	}
+	c.logf("giving up on worker %s\n", peer)
+	return false
+}
+
+// healthy reports whether peer answers a health check within timeout.
+func (c *Coordinator) healthy(ctx context.Context, peer string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerURL(peer, "/health"), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// send sends one shard to a peer and waits for its reply. Errors that say the peer is
+// in trouble, rather than the shard, are peerFailures.
+func (c *Coordinator) send(ctx context.Context, peer string, s shard, f *os.File, opts LoadOptions) (shardResult, error) {
+	if c.ShardTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ShardTimeout)
+		defer cancel()
+	}
+
+	q := url.Values{}
+	q.Set("name", s.name)
+	q.Set("strict", strconv.FormatBool(opts.Strict))
+	q.Set("examples", strconv.Itoa(opts.MaxExamples))
+	q.Set("invalid", opts.Validation.String())
+	var body io.Reader = io.NewSectionReader(f, s.start, s.end-s.start)
+	if s.header != nil {
+		body = io.MultiReader(bytes.NewReader(s.header), body)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peerURL(peer, "/shard")+"?"+q.Encode(), body)
+	if err != nil {
+		return shardResult{}, err
+	}
+	req.ContentLength = int64(len(s.header)) + s.end - s.start
+
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return shardResult{}, peerFailure{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusUnprocessableEntity:
+	case http.StatusBadRequest:
+		// The shard itself is at fault, so it isn't a peerFailure and won't be retried.
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return shardResult{}, fmt.Errorf("%s: worker %s: %s", s.name, peer, strings.TrimSpace(string(msg)))
+	default:
+		return shardResult{}, peerFailure{fmt.Errorf("%s", resp.Status)}
+	}
+
+	var reply shardReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return shardResult{}, peerFailure{fmt.Errorf("bad reply: %v", err)}
+	}
+	res := shardResult{sum: reply.Sum.sum(), rejects: reply.Rejects.rejects(s.name), lines: reply.Lines}
+	if reply.Error != nil {
+		res.err = reply.Error.parseError(s.name, false)
+	}
+	return res, nil
+}
+
+func (c *Coordinator) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+func (c *Coordinator) logf(format string, args ...interface{}) {
+	if c.Log != nil {
+		fmt.Fprintf(c.Log, format, args...)
+	}
+}
+
+// peerURL turns a peer address into the URL for path.
+func peerURL(peer, path string) string {
+	if !strings.Contains(peer, "://") {
+		peer = "http://" + peer
+	}
+	return strings.TrimRight(peer, "/") + path
+}
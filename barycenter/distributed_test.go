@@ -0,0 +1,160 @@
+package barycenter
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTemp writes data to a file called name in a temporary directory, and returns its path.
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// shardWorker starts a worker serving NewShardHandler on localhost.
+func shardWorker(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(NewShardHandler(2))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// A worker that stalls past ShardTimeout, and one that fails its first shard, should
+// have their shards handed to the others, and the result should be just what a single
+// process gets.
+func TestCoordinatorReassignsShards(t *testing.T) {
+	path := writeTemp(t, "bodies.txt", fixedLines(20000))
+
+	// The stalled worker never answers a shard, and fails its health checks, so it's
+	// left alone after its first shard times out.
+	var stalled int32
+	release := make(chan struct{})
+	stall := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			http.Error(w, "stalled", http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&stalled, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer stall.Close()
+	defer close(release)
+
+	// The flaky worker fails its first shard, then comes back.
+	var flaked int32
+	good := NewShardHandler(2)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/shard" && atomic.AddInt32(&flaked, 1) == 1 {
+			http.Error(w, "out of memory", http.StatusInternalServerError)
+			return
+		}
+		good.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+
+	var log bytes.Buffer
+	c := &Coordinator{
+		Peers:         []string{stall.URL, flaky.URL, shardWorker(t).Listener.Addr().String()},
+		ShardSize:     16 << 10,
+		ShardTimeout:  200 * time.Millisecond,
+		RetryInterval: 10 * time.Millisecond,
+		GiveUpAfter:   100 * time.Millisecond,
+		Log:           &log,
+	}
+	opts := LoadOptions{MaxExamples: 5}
+	got, _, err := c.Run([]string{path}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := StreamFile(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&stalled) == 0 || atomic.LoadInt32(&flaked) == 0 {
+		t.Fatalf("the failing workers weren't both sent shards: %s", log.String())
+	}
+	if !strings.Contains(log.String(), "worker "+stall.URL+" failed") {
+		t.Errorf("log doesn't say the stalled worker timed out: %s", log.String())
+	}
+	if got.Count != want.Count {
+		t.Fatalf("got %d points, want %d", got.Count, want.Count)
+	}
+	g, w := got.Weighted(), want.Weighted()
+	if !closeTo(g.X, w.X) || !closeTo(g.Y, w.Y) || !closeTo(g.Z, w.Z) || !closeTo(g.Mass, w.Mass) {
+		t.Errorf("got weighted sum %v, want %v", g, w)
+	}
+}
+
+func TestCoordinatorNoWorkers(t *testing.T) {
+	path := writeTemp(t, "bodies.txt", fixedLines(100))
+	var peers []string
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewServer(NewShardHandler(1))
+		srv.Close()
+		peers = append(peers, srv.URL)
+	}
+	c := &Coordinator{
+		Peers:         peers,
+		MaxAttempts:   10,
+		RetryInterval: 10 * time.Millisecond,
+		GiveUpAfter:   50 * time.Millisecond,
+	}
+	if _, _, err := c.Run([]string{path}, LoadOptions{}); !errors.Is(err, ErrNoWorkers) {
+		t.Errorf("got error %v, want ErrNoWorkers", err)
+	}
+	if _, _, err := (&Coordinator{}).Run([]string{path}, LoadOptions{}); !errors.Is(err, ErrNoWorkers) {
+		t.Errorf("with no peers, got error %v, want ErrNoWorkers", err)
+	}
+}
+
+// A strict mode failure deep in a later file should be reported on its line of that file,
+// once the lines of the shards before it are added on.
+func TestCoordinatorStrictLine(t *testing.T) {
+	first := writeTemp(t, "first.txt", fixedLines(5000))
+	second := writeTemp(t, "second.txt", withBadLines(fixedLines(20000), 9001, 15000))
+	c := &Coordinator{
+		Peers:     []string{shardWorker(t).URL, shardWorker(t).URL},
+		ShardSize: 16 << 10,
+	}
+	_, _, err := c.Run([]string{first, second}, LoadOptions{Strict: true})
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want a ParseError", err)
+	}
+	if perr.Name != second || perr.Line != 9001 || perr.Column != 1 {
+		t.Errorf("got error at %s:%d:%d, want %s:9001:1", perr.Name, perr.Line, perr.Column, second)
+	}
+}
+
+// A 400 means the shard is at fault, so it isn't tried again.
+func TestCoordinatorBadRequestIsFatal(t *testing.T) {
+	path := writeTemp(t, "bodies.txt", fixedLines(100))
+	var tries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		http.Error(w, "bad shard", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c := &Coordinator{Peers: []string{srv.URL}}
+	if _, _, err := c.Run([]string{path}, LoadOptions{}); err == nil || !strings.Contains(err.Error(), "bad shard") {
+		t.Errorf("got error %v, want the worker's", err)
+	}
+	if n := atomic.LoadInt32(&tries); n != 1 {
+		t.Errorf("the shard was sent %d times, want once", n)
+	}
+}
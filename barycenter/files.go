@@ -0,0 +1,246 @@
+package barycenter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxOpen is how many input files FilesOptions keeps open at once by default.
+// It's well under the usual descriptor limits, and plenty to keep the disks busy.
+const DefaultMaxOpen = 16
+
+// ExpandPaths turns command line arguments into the list of files they name.
+// Arguments with the glob metacharacters *, ? or [ are expanded, and must match something,
+// unless there's a file by that very name. Directories stand for every file in them,
+// however deeply nested, except hidden ones, in lexical order. Anything else, including
+// "-" for standard input and paths with backslashes in, is taken as it is.
+func ExpandPaths(args []string) ([]string, error) {
+	var names []string
+	for _, arg := range args {
+		if arg == "-" {
+			names = append(names, arg)
+			continue
+		}
+		matches := []string{arg}
+		// A backslash only escapes something in a pattern, so on its own it's just part of the name.
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if len(matches) == 0 {
+				_, statErr := os.Stat(arg)
+				switch {
+				case statErr == nil:
+					matches = []string{arg}
+				case err != nil:
+					return nil, fmt.Errorf("barycenter: bad pattern %q: %w", arg, err)
+				default:
+					return nil, fmt.Errorf("barycenter: nothing matches %q", arg)
+				}
+			}
+		}
+		for _, m := range matches {
+			files, err := filesIn(m)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, files...)
+		}
+	}
+	return names, nil
+}
+
+// filesIn lists the files in the directory called name, or just name itself if it isn't one.
+func filesIn(name string) ([]string, error) {
+	info, err := os.Stat(name)
+	if err != nil || !info.IsDir() {
+		// If it doesn't exist, loading it will say so.
+		return []string{name}, nil
+	}
+	var files []string
+	err = filepath.WalkDir(name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		hidden := path != name && strings.HasPrefix(d.Name(), ".")
+		switch {
+		case hidden && d.IsDir():
+			return filepath.SkipDir
+		case hidden || d.IsDir():
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("barycenter: no files in %s", name)
+	}
+	return files, err
+}
+
+// FilesOptions says how to load several files, and find their barycenters.
+type FilesOptions struct {
+	// LoadOptions are used for every file, except that each file's own name is used in
+	// diagnostics.
+	LoadOptions
+	// DecoderFor, if it's not nil, picks the decoder for each file, like DecoderForPath.
+	DecoderFor func(name string) Decoder
+	// MaxOpen is how many files are loaded at once, and so how many are open at once.
+	// If it's zero or less, DefaultMaxOpen is used.
+	MaxOpen int
+	// Strategy finds each file's barycenter once it's loaded. If it's nil, each file is
+	// streamed into running sums instead, like StreamFile does.
+	Strategy Strategy
+}
+
+// A FileResult is the barycenter of one of several files.
+type FileResult struct {
+	Name string
+	// Barycenter is the virtual body at the file's barycenter, carrying its mass.
+	// A file with no bodies has no barycenter, and it's left as the zero MassPoint.
+	Barycenter MassPoint
+	// Bodies is the number of bodies loaded, Rejected the number of malformed records
+	// skipped and Flagged the number of bodies loaded in spite of invalid values.
+	Bodies, Rejected, Flagged int
+}
+
+// ComputeFiles finds the barycenter of each of the named files. Up to o.MaxOpen files
+// are loaded at once, each with its own o.Workers workers, so a few big files and a lot
+// of small ones both keep every core busy. The results, and the rejected records, come
+// back in the order the files were named.
+//
+// Any file that fails fails the whole lot, like a file with no barycenter, or a strict mode
+// parse error; if several do, the error is the first one in file order. A file with no bodies
+// isn't an error, and it's left out of CombineFiles.
+func ComputeFiles(names []string, o FilesOptions) ([]FileResult, Rejects, error) {
+	maxOpen := o.MaxOpen
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpen
+	}
+	if maxOpen > len(names) {
+		maxOpen = len(names)
+	}
+	o.Progress.setTotal(totalSize(names))
+
+	results := make([]FileResult, len(names))
+	rejects := make([]Rejects, len(names))
+	errs := make([]error, len(names))
+	// Each worker takes the next file as soon as it's done with the last one, so there's
+	// never more than maxOpen of them open. Once one fails, the rest aren't started.
+	next := make(chan int)
+	var failed sync.Once
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < maxOpen; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], rejects[i], errs[i] = computeFile(names[i], o)
+				if errs[i] != nil {
+					failed.Do(func() { close(done) })
+				}
+			}
+		}()
+	}
+feed:
+	for i := range names {
+		select {
+		case next <- i:
+		case <-done:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	var all Rejects
+	for i := range names {
+		if errs[i] != nil {
+			return nil, Rejects{}, errs[i]
+		}
+		all.merge(rejects[i], o.MaxExamples)
+	}
+	return results, all, nil
+}
+
+// computeFile loads one file, and finds its barycenter.
+func computeFile(name string, o FilesOptions) (FileResult, Rejects, error) {
+	opts := o.LoadOptions
+	opts.Name = name
+	if o.DecoderFor != nil {
+		opts.Decoder = o.DecoderFor(name)
+	}
+	result := FileResult{Name: name}
+	var rejects Rejects
+	var err error
+	if o.Strategy == nil {
+		var sum WeightedSum
+		sum, rejects, err = StreamFile(name, opts)
+		result.Bodies = sum.Count
+		if err == nil && sum.Count > 0 {
+			result.Barycenter, err = sum.Barycenter()
+		}
+	} else {
+		var points []MassPoint
+		points, rejects, err = LoadFile(name, opts)
+		result.Bodies = len(points)
+		if err == nil && len(points) > 0 {
+			ctx := opts.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			result.Barycenter, err = ComputeContext(ctx, o.Strategy, points)
+		}
+	}
+	if err != nil {
+		// Parse errors already name the file, and so do errors opening it.
+		var perr *ParseError
+		var pathErr *fs.PathError
+		stopped := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+		if !errors.As(err, &perr) && !errors.As(err, &pathErr) && !stopped {
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+		return FileResult{}, Rejects{}, err
+	}
+	result.Rejected, result.Flagged = rejects.Count, rejects.Flagged
+	return result, rejects, nil
+}
+
+// totalSize adds up the sizes of the named files, for progress reports. If any of them
+// isn't a regular file, the total isn't known, and it's zero.
+func totalSize(names []string) int64 {
+	var total int64
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+// CombineFiles finds the barycenter of the whole system from the barycenters of its
+// files, using s, or the CompensatedSum strategy if s is nil, as it is for streams.
+// Each file's barycenter carries the file's mass, so this is the same as the barycenter
+// of all of the bodies, give or take rounding. Files with no bodies are left out.
+func CombineFiles(results []FileResult, s Strategy) (MassPoint, error) {
+	if s == nil {
+		s = CompensatedSum{}
+	}
+	points := make([]MassPoint, 0, len(results))
+	for _, r := range results {
+		if r.Bodies > 0 {
+			points = append(points, r.Barycenter)
+		}
+	}
+	// The files' barycenters are in file order, so the result doesn't depend on which
+	// file finished loading first.
+	return s.Compute(points)
+}
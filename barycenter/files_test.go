@@ -0,0 +1,181 @@
+package barycenter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeTree creates the named files under dir, with the same small body file in each.
+func makeTree(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("1:2:3:4\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, "a.txt", "b.txt", "c.bin", "sub/d.txt", "sub/deeper/e.txt", "sub/.hidden.txt",
+		".hidden/f.txt", `back\slash.txt`, "odd[1].txt", "empty/.keep")
+	in := func(names ...string) []string {
+		for i, name := range names {
+			names[i] = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"pattern", in("*.txt"), in("a.txt", "b.txt", `back\slash.txt`, "odd[1].txt")},
+		// Unlike a shell, a pattern matches hidden files too; only directories skip them.
+		{"pattern in a directory", in("sub/*.txt"), in("sub/.hidden.txt", "sub/d.txt")},
+		{"directory", in("sub"), in("sub/d.txt", "sub/deeper/e.txt")},
+		{"files in the order given", in("c.bin", "a.txt"), in("c.bin", "a.txt")},
+		{"standard input", []string{"-"}, []string{"-"}},
+		// A file that doesn't exist is left for loading it to complain about.
+		{"missing file", in("nope.txt"), in("nope.txt")},
+		// A backslash on its own isn't a metacharacter, so the path is taken as it is.
+		{"backslash", in(`back\slash.txt`), in(`back\slash.txt`)},
+		// A name that happens to look like a pattern still names itself, if nothing else matches.
+		{"file named like a pattern", in("odd[1].txt"), in("odd[1].txt")},
+	} {
+		got, err := ExpandPaths(tc.args)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no match", in("*.csv"), "nothing matches"},
+		{"bad pattern", in("[.txt"), "bad pattern"},
+		{"no files", in("empty"), "no files in"},
+	} {
+		if _, err := ExpandPaths(tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one saying %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+// countingStrategy is Linear, except that it keeps track of how many calls are under way
+// at once, and takes its time, so they overlap.
+type countingStrategy struct {
+	mu            sync.Mutex
+	running, most int
+	delay         time.Duration
+}
+
+func (s *countingStrategy) Compute(points []MassPoint) (MassPoint, error) {
+	s.mu.Lock()
+	s.running++
+	if s.running > s.most {
+		s.most = s.running
+	}
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	s.running--
+	s.mu.Unlock()
+	return Linear{}.Compute(points)
+}
+
+func TestComputeFilesMaxOpen(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 12; i++ {
+		name := filepath.Join(dir, string(rune('a'+i))+".txt")
+		text := []byte(strings.Repeat("1:2:3:4\n", i+1))
+		if err := os.WriteFile(name, text, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	for _, maxOpen := range []int{1, 3, 5} {
+		s := &countingStrategy{delay: 20 * time.Millisecond}
+		results, _, err := ComputeFiles(names, FilesOptions{MaxOpen: maxOpen, Strategy: s})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.most != maxOpen {
+			t.Errorf("MaxOpen %d: %d files were worked on at once", maxOpen, s.most)
+		}
+		for i, r := range results {
+			if r.Name != names[i] || r.Bodies != i+1 {
+				t.Errorf("MaxOpen %d: result %d is %+v, want %s with %d bodies", maxOpen, i, r, names[i], i+1)
+			}
+		}
+	}
+}
+
+// Rejects come back in file order, and when several files fail, the error is for the first.
+func TestComputeFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("good.txt", "1:2:3:4\n")
+	rejecting := write("rejecting.txt", "1:2:3:4\nbad\n5:6:7:8\nworse\n")
+	bad1 := write("bad1.txt", "1:2:3:4\n1:2:x:4\n")
+	bad2 := write("bad2.txt", "1:2:y:4\n")
+	empty := write("empty.txt", "")
+
+	results, rejects, err := ComputeFiles([]string{rejecting, good, empty, rejecting}, FilesOptions{
+		LoadOptions: LoadOptions{MaxExamples: 10}, MaxOpen: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejects.Count != 4 || len(rejects.Examples) != 4 {
+		t.Fatalf("got %d rejects with %d examples, want 4 of each", rejects.Count, len(rejects.Examples))
+	}
+	for i, want := range []int{2, 4, 2, 4} {
+		if e := rejects.Examples[i]; e.Name != rejecting || e.Line != want {
+			t.Errorf("reject %d is at %s:%d, want %s:%d", i, e.Name, e.Line, rejecting, want)
+		}
+	}
+	if results[2].Bodies != 0 || results[2].Barycenter != (MassPoint{}) {
+		t.Errorf("empty file: got %+v", results[2])
+	}
+
+	for i := 0; i < 10; i++ {
+		_, _, err := ComputeFiles([]string{good, bad2, good, bad1}, FilesOptions{
+			LoadOptions: LoadOptions{Strict: true}, MaxOpen: 4,
+		})
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Name != bad2 || perr.Line != 1 {
+			t.Fatalf("got error %v, want the one in %s", err, bad2)
+		}
+	}
+	_, _, err = ComputeFiles([]string{good, filepath.Join(dir, "missing.txt"), bad1}, FilesOptions{
+		LoadOptions: LoadOptions{Strict: true}, MaxOpen: 1,
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want the missing file's", err)
+	}
+}
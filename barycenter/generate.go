@@ -0,0 +1,42 @@
+package barycenter
+
+import "math/rand"
+
+// The bounds genBodies generates bodies within.
+const (
+	// GenPosMax is the furthest a generated body is from the origin, in any axis.
+	GenPosMax = 100
+	// GenMassMax bounds the mass of a generated body, which is from 1 to GenMassMax-1.
+	GenMassMax = 5
+)
+
+// RandomBody generates a body the way genBodies does, with whole-numbered coordinates and
+// mass. If velMax is more than zero, the body also gets a velocity of up to velMax in each axis.
+// Generating from the same source gives the same bodies, so benchmarks can be repeated.
+func RandomBody(rng *rand.Rand, velMax int) Body {
+	// Go doesn't have functions to generate negative random integers,
+	// so we generate a positive integer with twice the range and subtract.
+	var b Body
+	b.X = float64(rng.Intn(GenPosMax*2) - GenPosMax)
+	b.Y = float64(rng.Intn(GenPosMax*2) - GenPosMax)
+	b.Z = float64(rng.Intn(GenPosMax*2) - GenPosMax)
+	// On the other hand, mass can't be negative (or zero), so this is easier.
+	b.Mass = float64(rng.Intn(GenMassMax-1) + 1)
+	// Velocities work just like positions.
+	if velMax > 0 {
+		b.Velocity.X = float64(rng.Intn(velMax*2+1) - velMax)
+		b.Velocity.Y = float64(rng.Intn(velMax*2+1) - velMax)
+		b.Velocity.Z = float64(rng.Intn(velMax*2+1) - velMax)
+	}
+	return b
+}
+
+// RandomMassPoints generates n mass points from the given seed, as genBodies would.
+func RandomMassPoints(n int, seed int64) []MassPoint {
+	rng := rand.New(rand.NewSource(seed))
+	points := make([]MassPoint, n)
+	for i := range points {
+		points[i] = RandomBody(rng, 0).MassPoint
+	}
+	return points
+}
@@ -0,0 +1,282 @@
+package barycenter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Grouping says how to split bodies into groups, each with its own barycenter.
+// Bodies can be grouped by their labels, by the cell of a 3D grid they fall in, or both.
+// The zero Grouping puts every body in one group.
+type Grouping struct {
+	// ByLabel groups bodies by label. Bodies without a label make up a group of their own.
+	ByLabel bool
+	// Grid is the size of a grid cell along each axis. An axis whose size is zero or less
+	// isn't split, so a grid of {10, 10, 0} cuts space into columns.
+	Grid Vector
+	// Origin is a corner of the grid cell (0, 0, 0).
+	Origin Vector
+}
+
+// gridded reports whether g splits space into cells at all.
+func (g Grouping) gridded() bool {
+	return g.Grid.X > 0 || g.Grid.Y > 0 || g.Grid.Z > 0
+}
+
+// key finds the group b belongs to.
+func (g Grouping) key(b Body) GroupKey {
+	var k GroupKey
+	if g.ByLabel {
+		k.Label = b.Label
+	}
+	k.Cell[0] = cellIndex(b.X, g.Origin.X, g.Grid.X)
+	k.Cell[1] = cellIndex(b.Y, g.Origin.Y, g.Grid.Y)
+	k.Cell[2] = cellIndex(b.Z, g.Origin.Z, g.Grid.Z)
+	return k
+}
+
+// cellIndex finds the cell that v falls in along one axis of the grid.
+func cellIndex(v, origin, size float64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	c := math.Floor((v - origin) / size)
+	if math.IsNaN(c) {
+		return 0
+	}
+	// Bodies way out past the grid share its outermost cells.
+	return int64(math.Max(-1<<62, math.Min(1<<62, c)))
+}
+
+// A GroupKey names a group. Label is empty unless the grouping is by label, and Cell is
+// all zeros unless it's by grid cell.
+type GroupKey struct {
+	Label string
+	Cell  [3]int64
+}
+
+func (k GroupKey) String() string {
+	cell := fmt.Sprintf("(%d, %d, %d)", k.Cell[0], k.Cell[1], k.Cell[2])
+	if k.Label == "" {
+		return cell
+	}
+	return k.Label + " " + cell
+}
+
+// less orders keys by label, and then by cell.
+func (k GroupKey) less(other GroupKey) bool {
+	if k.Label != other.Label {
+		return k.Label < other.Label
+	}
+	for axis := range k.Cell {
+		if k.Cell[axis] != other.Cell[axis] {
+			return k.Cell[axis] < other.Cell[axis]
+		}
+	}
+	return false
+}
+
+// A Group is the barycenter of one group of bodies.
+type Group struct {
+	GroupKey
+	// Barycenter is the virtual body at the group's barycenter, carrying its mass.
+	Barycenter MassPoint
+	// Bodies is the number of bodies in the group.
+	Bodies int
+}
+
+// A groupSink folds each body into the partial sum for its group. It's the map side of
+// the map-reduce: every worker has its own, so there's no locking.
+type groupSink struct {
+	grouping Grouping
+	sums     map[GroupKey]*WeightedSum
+}
+
+func newGroupSink(g Grouping) *groupSink {
+	return &groupSink{grouping: g, sums: make(map[GroupKey]*WeightedSum)}
+}
+
+func (s *groupSink) addBody(b Body) {
+	k := s.grouping.key(b)
+	sum := s.sums[k]
+	if sum == nil {
+		sum = &WeightedSum{}
+		s.sums[k] = sum
+	}
+	sum.Add(b.MassPoint)
+}
+
+// GroupBarycenters finds the barycenter of every group of bodies, splitting the bodies
+// between workers goroutines. If workers is zero or less, GOMAXPROCS is used.
+// The groups come back sorted by label, and then by cell.
+func GroupBarycenters(bodies []Body, g Grouping, workers int) ([]Group, error) {
+	if len(bodies) == 0 {
+		return nil, ErrNoPoints
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(bodies) {
+		workers = len(bodies)
+	}
+
+	parts := make([]*groupSink, workers)
+	var wg sync.WaitGroup
+	for w := range parts {
+		lo := len(bodies) * w / workers
+		hi := len(bodies) * (w + 1) / workers
+		parts[w] = newGroupSink(g)
+		wg.Add(1)
+		go func(part *groupSink) {
+			defer wg.Done()
+			for _, b := range bodies[lo:hi] {
+				part.addBody(b)
+			}
+		}(parts[w])
+	}
+	wg.Wait()
+	return reduceGroups(parts, workers)
+}
+
+// GroupFile is the grouped version of StreamFile: no bodies are kept, just a partial sum
+// per group. Uncompressed text and binary files are split into byte ranges, each folded
+// into its own set of sums concurrently; anything else is read in one go.
+func GroupFile(name string, opts LoadOptions, g Grouping) ([]Group, Rejects, error) {
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	in, err := openInput(name)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	defer in.Close()
+
+	var parts []*groupSink
+	var rejects Rejects
+	if in.size < 0 || customDecoder(opts) != nil {
+		parts = []*groupSink{newGroupSink(g)}
+		rejects, err = scanLines(in, opts, parts[0])
+	} else {
+		parts = make([]*groupSink, rangeCount(in.size, opts.Workers))
+		sinks := make([]sink, len(parts))
+		for i := range parts {
+			parts[i] = newGroupSink(g)
+			sinks[i] = parts[i]
+		}
+		rejects, err = scanRanges(in.ranges(opts), in.size, opts, sinks)
+	}
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	groups, err := reduceGroups(parts, opts.Workers)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	return groups, rejects, nil
+}
+
+// A keyedSum is one group's partial sum, on its way to a reducer.
+type keyedSum struct {
+	key GroupKey
+	sum *WeightedSum
+}
+
+// A groupFailure is a group with no barycenter.
+type groupFailure struct {
+	key GroupKey
+	err error
+}
+
+// reduceGroups is the reduce side of the map-reduce. First each part's sums are shuffled
+// into one bucket per reducer, by the hash of their keys, with a goroutine per part. Then
+// each of workers reducers merges its buckets in the order of parts, so the results don't
+// depend on how many reducers there are. If any groups have no barycenter, the error is
+// for the first of them in sorted order.
+func reduceGroups(parts []*groupSink, workers int) ([]Group, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	seed := maphash.MakeSeed()
+	shard := func(k GroupKey) int {
+		var buf [24]byte
+		for axis, c := range k.Cell {
+			binary.LittleEndian.PutUint64(buf[8*axis:], uint64(c))
+		}
+		return int((maphash.String(seed, k.Label) ^ maphash.Bytes(seed, buf[:])) % uint64(workers))
+	}
+
+	buckets := make([][][]keyedSum, len(parts))
+	var wg sync.WaitGroup
+	for p, part := range parts {
+		buckets[p] = make([][]keyedSum, workers)
+		wg.Add(1)
+		go func(out [][]keyedSum, sums map[GroupKey]*WeightedSum) {
+			defer wg.Done()
+			for k, sum := range sums {
+				r := shard(k)
+				out[r] = append(out[r], keyedSum{k, sum})
+			}
+		}(buckets[p], part.sums)
+	}
+	wg.Wait()
+
+	shards := make([][]Group, workers)
+	// Each reducer keeps the first of its groups, in sorted order, that has no barycenter,
+	// so which failure is reported doesn't depend on how the keys were hashed.
+	fails := make([]*groupFailure, workers)
+	for r := range shards {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			sums := make(map[GroupKey]*WeightedSum)
+			var keys []GroupKey
+			for p := range buckets {
+				for _, ks := range buckets[p][r] {
+					sum := sums[ks.key]
+					if sum == nil {
+						sum = &WeightedSum{}
+						sums[ks.key] = sum
+						keys = append(keys, ks.key)
+					}
+					sum.Merge(*ks.sum)
+				}
+			}
+			for _, k := range keys {
+				b, err := sums[k].Barycenter()
+				if err != nil {
+					if f := fails[r]; f == nil || k.less(f.key) {
+						fails[r] = &groupFailure{k, err}
+					}
+					continue
+				}
+				shards[r] = append(shards[r], Group{GroupKey: k, Barycenter: b, Bodies: sums[k].Count})
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	var failed *groupFailure
+	for _, f := range fails {
+		if f != nil && (failed == nil || f.key.less(failed.key)) {
+			failed = f
+		}
+	}
+	if failed != nil {
+		return nil, fmt.Errorf("group %s: %w", failed.key, failed.err)
+	}
+
+	var groups []Group
+	for _, reduced := range shards {
+		groups = append(groups, reduced...)
+	}
+	if len(groups) == 0 {
+		return nil, ErrNoPoints
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].less(groups[j].GroupKey) })
+	return groups, nil
+}
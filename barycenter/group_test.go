@@ -0,0 +1,104 @@
+package barycenter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// labelledBodies makes n random bodies, spread between the given labels in turn.
+func labelledBodies(n int, seed int64, labels ...string) []Body {
+	bodies := randomBodies(n, seed, 0)
+	for i := range bodies {
+		bodies[i].Label = labels[i%len(labels)]
+	}
+	return bodies
+}
+
+// checkGroups checks each group's barycenter against Linear on the bodies in that group.
+func checkGroups(t *testing.T, what string, bodies []Body, g Grouping, groups []Group) {
+	t.Helper()
+	members := make(map[GroupKey][]MassPoint)
+	for _, b := range bodies {
+		k := g.key(b)
+		members[k] = append(members[k], b.MassPoint)
+	}
+	if len(groups) != len(members) {
+		t.Fatalf("%s: got %d groups, want %d", what, len(groups), len(members))
+	}
+	for i, gr := range groups {
+		if i > 0 && !groups[i-1].less(gr.GroupKey) {
+			t.Errorf("%s: group %s comes after %s", what, gr.GroupKey, groups[i-1].GroupKey)
+		}
+		want, err := Linear{}.Compute(members[gr.GroupKey])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gr.Bodies != len(members[gr.GroupKey]) || !closeToPoint(gr.Barycenter, want) {
+			t.Errorf("%s: group %s: got %v from %d bodies, want %v from %d",
+				what, gr.GroupKey, gr.Barycenter, gr.Bodies, want, len(members[gr.GroupKey]))
+		}
+	}
+}
+
+func TestGroupBarycentersMatchLinear(t *testing.T) {
+	bodies := labelledBodies(10007, 1, "", "alpha", "beta", "gamma", "delta")
+	for _, g := range []Grouping{
+		{},
+		{ByLabel: true},
+		{Grid: Vector{50, 50, 50}},
+		{ByLabel: true, Grid: Vector{100, 0, 0}, Origin: Vector{-7, 0, 0}},
+	} {
+		for _, workers := range []int{1, 2, 5, 16} {
+			groups, err := GroupBarycenters(bodies, g, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGroups(t, fmt.Sprintf("%+v, %d workers", g, workers), bodies, g, groups)
+		}
+	}
+}
+
+func TestGroupFileMatchesLinear(t *testing.T) {
+	bodies := labelledBodies(20000, 2, "a", "b", "c")
+	var text []byte
+	for _, b := range bodies {
+		text = AppendBodyText(text, b)
+	}
+	path := filepath.Join(t.TempDir(), "labelled.txt")
+	if err := os.WriteFile(path, text, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g := Grouping{ByLabel: true}
+	for _, workers := range []int{1, 3, 8} {
+		groups, _, err := GroupFile(path, LoadOptions{Workers: workers}, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGroups(t, fmt.Sprintf("file, %d workers", workers), bodies, g, groups)
+	}
+}
+
+// When several groups have no barycenter, the error should always be for the first one,
+// whatever the number of reducers or the seed their keys are hashed with.
+func TestGroupErrorIsFirstInOrder(t *testing.T) {
+	var bodies []Body
+	for _, label := range []string{"m", "k", "z", "c", "q"} {
+		bodies = append(bodies, Body{MassPoint: MassPoint{1, 2, 3, 4}, Label: label + "-ok"})
+		// Each of these groups has masses that cancel out.
+		bodies = append(bodies,
+			Body{MassPoint: MassPoint{1, 1, 1, 2}, Label: label},
+			Body{MassPoint: MassPoint{2, 2, 2, -2}, Label: label})
+	}
+	for _, workers := range []int{1, 2, 3, 8} {
+		for i := 0; i < 20; i++ {
+			_, err := GroupBarycenters(bodies, Grouping{ByLabel: true}, workers)
+			if !errors.Is(err, ErrZeroMass) || !strings.HasPrefix(err.Error(), "group c ") {
+				t.Fatalf("%d workers: got error %v, want one for group c", workers, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package barycenter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// batchSize is the number of lines the concurrent loader hands to a worker at once.
+// Sending lines in batches keeps channel traffic down to a handful of operations per
+// thousand lines, rather than one goroutine per line.
+const batchSize = 1024
+
+// fieldNames names the fields of a body line, in order, for diagnostics.
+// The velocity fields are optional.
+var fieldNames = [7]string{"x", "y", "z", "mass", "vx", "vy", "vz"}
+
+// LoadOptions controls how body files are loaded.
+type LoadOptions struct {
+	// Name is the file name used in diagnostics.
+	Name string
+	// Workers is the number of parsing goroutines LoadConcurrent uses.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+	// Strict makes loading fail at the first malformed line. Otherwise malformed
+	// lines are skipped, and counted in the Rejects returned by the loader.
+	Strict bool
+	// MaxExamples is how many rejected lines are kept as examples in lenient mode.
+	MaxExamples int
+	// Decoder reads the input format. If it's nil, the input is read as text or binary,
+	// whichever it turns out to be. Other decoders read the input in a single pass.
+	Decoder Decoder
+	// Validation is what to do with bodies that have non-positive masses, or NaN or
+	// infinite values. The zero value rejects them.
+	Validation Validation
+	// Context, if it's not nil, stops the load once it's done: the loaders stop reading,
+	// their goroutines finish up, and they return the context's error.
+	Context context.Context
+	// Progress, if it's not nil, is updated as the input is read.
+	Progress *Progress
+	// Buffered makes the file loaders read regular files through buffers, the way they
+	// read pipes, rather than mapping them into memory and parsing them in place.
+	Buffered bool
+}
+
+// A ParseError describes a body line that couldn't be parsed, or that held invalid values.
+// In the binary format, Line is the record number and Column the byte in the record.
+type ParseError struct {
+	Name   string // the file name, if known
+	Line   int    // 1-based line number, or 0 if unknown
+	Column int    // 1-based byte column where the problem starts
+	Text   string // the offending line, without its line ending
+	Err    error
+
+	// flagged is set when the body was kept in spite of the problem.
+	flagged bool
+}
+
+func (e *ParseError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = "input"
+	}
+	return fmt.Sprintf("%s:%d:%d: %v", name, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Rejects summarizes the malformed lines skipped by a lenient load, and the bodies
+// with invalid values that were kept under FlagInvalid.
+type Rejects struct {
+	// Count is the total number of rejected lines.
+	Count int
+	// Examples holds the first few rejected lines, in file order.
+	Examples []*ParseError
+	// Flagged is the number of bodies kept in spite of invalid values.
+	Flagged int
+	// FlaggedExamples holds the first few of those, in file order.
+	FlaggedExamples []*ParseError
+}
+
+// note records a problem with a record. A flagged problem is only counted, since the body
+// is kept. Otherwise the record is rejected, and in strict mode the problem is returned
+// so the load can stop.
+func (r *Rejects) note(perr *ParseError, opts LoadOptions) *ParseError {
+	if perr.flagged {
+		r.Flagged++
+		if len(r.FlaggedExamples) < opts.MaxExamples {
+			r.FlaggedExamples = append(r.FlaggedExamples, perr)
+		}
+		return nil
+	}
+	if opts.Strict {
+		return perr
+	}
+	r.add(perr, opts.MaxExamples)
+	return nil
+}
+
+// add records a rejected line, keeping it as an example if there's room.
+func (r *Rejects) add(err *ParseError, maxExamples int) {
+	r.Count++
+	if len(r.Examples) < maxExamples {
+		r.Examples = append(r.Examples, err)
+	}
+}
+
+// merge folds in the rejects from a later part of the same input.
+func (r *Rejects) merge(other Rejects, maxExamples int) {
+	r.Count += other.Count
+	for _, e := range other.Examples {
+		if len(r.Examples) >= maxExamples {
+			break
+		}
+		r.Examples = append(r.Examples, e)
+	}
+	r.Flagged += other.Flagged
+	for _, e := range other.FlaggedExamples {
+		if len(r.FlaggedExamples) >= maxExamples {
+			break
+		}
+		r.FlaggedExamples = append(r.FlaggedExamples, e)
+	}
+}
+
+// shift renumbers the examples from a part of the input that starts after offset lines.
+func (r *Rejects) shift(offset int) {
+	for _, e := range r.Examples {
+		e.Line += offset
+	}
+	for _, e := range r.FlaggedExamples {
+		e.Line += offset
+	}
+}
+
+// sortExamples puts examples gathered out of order back in file order, and keeps the first
+// maxExamples of each kind.
+func (r *Rejects) sortExamples(maxExamples int) {
+	if maxExamples < 0 {
+		maxExamples = 0
+	}
+	for _, examples := range []*[]*ParseError{&r.Examples, &r.FlaggedExamples} {
+		list := *examples
+		sort.Slice(list, func(i, j int) bool { return list[i].Line < list[j].Line })
+		if len(list) > maxExamples {
+			*examples = list[:maxExamples]
+		}
+	}
+}
+
+// WriteSummary writes the number of rejected lines and the examples to w, followed by
+// the number of flagged bodies and their examples, if there were any.
+func (r Rejects) WriteSummary(w io.Writer) error {
+	if r.Count > 0 || r.Flagged == 0 {
+		if _, err := fmt.Fprintf(w, "Rejected %d malformed or invalid records.\n", r.Count); err != nil {
+			return err
+		}
+		if err := writeExamples(w, r.Examples, r.Count); err != nil {
+			return err
+		}
+	}
+	if r.Flagged > 0 {
+		if _, err := fmt.Fprintf(w, "Kept %d bodies with invalid values.\n", r.Flagged); err != nil {
+			return err
+		}
+		return writeExamples(w, r.FlaggedExamples, r.Flagged)
+	}
+	return nil
+}
+
+// writeExamples writes the examples of count problems to w.
+func writeExamples(w io.Writer, examples []*ParseError, count int) error {
+	for _, e := range examples {
+		if _, err := fmt.Fprintf(w, "  %v\n", e); err != nil {
+			return err
+		}
+		if e.Text == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "    %s\n", e.Text); err != nil {
+			return err
+		}
+	}
+	if more := count - len(examples); more > 0 && len(examples) > 0 {
+		if _, err := fmt.Fprintf(w, "  ... and %d more.\n", more); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseMassPoint parses a single body line in the x:y:z:mass format written by genBodies.
+// Lines that go on to give a velocity are accepted too, and the velocity is ignored.
+// If the line is malformed, the error is a *ParseError saying which column is at fault.
+func ParseMassPoint(s string) (MassPoint, error) {
+	b, err := ParseBody(s)
+	return b.MassPoint, err
+}
+
+// ParseBody parses a single body line, which may give the body's velocity after its mass,
+// as x:y:z:mass:vx:vy:vz. Without one, the body is at rest. Either way, the line can end
+// with a label for the body's group, as in x:y:z:mass:label.
+// If the line is malformed, the error is a *ParseError saying which column is at fault.
+func ParseBody(s string) (Body, error) {
+	b, perr := parseBodyBytes([]byte(s))
+	if perr != nil {
+		return Body{}, perr
+	}
+	return b, nil
+}
+
+// parseBodyBytes does the work of ParseBody, directly on the bytes of a line.
+func parseBodyBytes(line []byte) (Body, *ParseError) {
+	line = bytes.TrimRight(line, "\r\n")
+
+	// First find where each field starts. ends[i] is where field i ends, at its colon
+	// or the end of the line.
+	const maxFields = len(fieldNames) + 1
+	var ends [maxFields]int
+	n := 0
+	for start := 0; ; n++ {
+		j := bytes.IndexByte(line[start:], ':')
+		if j < 0 {
+			ends[n] = len(line)
+			n++
+			break
+		}
+		if n == maxFields-1 {
+			return Body{}, &ParseError{Column: start + j + 1, Text: string(line),
+				Err: errors.New("too many fields")}
+		}
+		ends[n] = start + j
+		start += j + 1
+	}
+
+	// A mass point has four numbers and a moving body seven. One more field than that is a label.
+	numbers := n
+	if n == 5 || n == maxFields {
+		numbers--
+	}
+	var vals [len(fieldNames)]float64
+	start := 0
+	for i := 0; i < numbers; i++ {
+		v, err := parseFloat(bytes.TrimSpace(line[start:ends[i]]))
+		if err != nil {
+			return Body{}, &ParseError{Column: start + 1, Text: string(line),
+				Err: fmt.Errorf("invalid %s value %q", fieldNames[i], line[start:ends[i]])}
+		}
+		vals[i] = v
+		start = ends[i] + 1
+	}
+	if numbers != 4 && numbers != len(vals) {
+		return Body{}, &ParseError{Column: len(line) + 1, Text: string(line),
+			Err: fmt.Errorf("missing %s field", fieldNames[numbers])}
+	}
+
+	b := Body{
+		MassPoint: MassPoint{vals[0], vals[1], vals[2], vals[3]},
+		Velocity:  Vector{vals[4], vals[5], vals[6]},
+	}
+	if numbers < n {
+		b.Label = string(bytes.TrimSpace(line[start:]))
+	}
+	return b, nil
+}
+
+// parseBodyLine parses the line numbered lineNo, and checks its values against
+// opts.Validation. Blank lines are ignored, and come back with ok set to false.
+// A flagged body comes back with both ok set and an error.
+func parseBodyLine(line []byte, lineNo int, opts LoadOptions) (b Body, ok bool, perr *ParseError) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return Body{}, false, nil
+	}
+	b, perr = parseBodyBytes(line)
+	if perr == nil {
+		var field int
+		if field, perr = checkBody(b, opts.Validation); perr != nil {
+			line = bytes.TrimRight(line, "\r\n")
+			perr.Column = fieldColumn(line, field)
+			perr.Text = string(line)
+		}
+	}
+	if perr != nil {
+		perr.Name = opts.Name
+		perr.Line = lineNo
+		return b, perr.flagged, perr
+	}
+	return b, true, nil
+}
+
+// fieldColumn finds the 1-based column where the given field of a body line starts.
+func fieldColumn(line []byte, field int) int {
+	start := 0
+	for i := 0; i < field; i++ {
+		j := bytes.IndexByte(line[start:], ':')
+		if j < 0 {
+			break
+		}
+		start += j + 1
+	}
+	return start + 1
+}
+
+// A sink collects the bodies parsed from one part of the input. Loaders collect them
+// in a slice, while streams fold them straight into a WeightedSum. Sinks that only
+// want mass points just drop the velocities.
+type sink interface {
+	addBody(b Body)
+}
+
+// pointSink collects points in a slice, in the order they're added.
+type pointSink struct {
+	points []MassPoint
+}
+
+func (s *pointSink) addBody(b Body) { s.points = append(s.points, b.MassPoint) }
+
+// parseLines parses a run of lines into s, the first of which is line number first.
+// In strict mode it stops at the first malformed line and returns it as the error.
+func parseLines(lines [][]byte, first int, opts LoadOptions, s sink) (Rejects, *ParseError) {
+	var rejects Rejects
+	for i, line := range lines {
+		b, ok, perr := parseBodyLine(line, first+i, opts)
+		if perr != nil {
+			if err := rejects.note(perr, opts); err != nil {
+				return Rejects{}, err
+			}
+		}
+		if ok {
+			s.addBody(b)
+		}
+	}
+	return rejects, nil
+}
+
+// Load reads body lines from r one at a time, without any concurrency.
+// If r holds the binary format instead, Load reads that.
+func Load(r io.Reader, opts LoadOptions) ([]MassPoint, Rejects, error) {
+	var points pointSink
+	rejects, err := scanLines(r, opts, &points)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	return points.points, rejects, nil
+}
+
+// A funcSink hands each point to a function.
+type funcSink func(p MassPoint)
+
+func (f funcSink) addBody(b Body) { f(b.MassPoint) }
+
+// Walk reads body records from r one at a time, in order, and calls fn for each point.
+// Like Load, it handles both the text and the binary format.
+func Walk(r io.Reader, opts LoadOptions, fn func(MassPoint)) (Rejects, error) {
+	return scanLines(r, opts, funcSink(fn))
+}
+
+// A bodyFuncSink hands each body to a function.
+type bodyFuncSink func(b Body)
+
+func (f bodyFuncSink) addBody(b Body) { f(b) }
+
+// WalkBodies is like Walk, except fn gets whole bodies, velocities and all.
+func WalkBodies(r io.Reader, opts LoadOptions, fn func(Body)) (Rejects, error) {
+	return scanLines(r, opts, bodyFuncSink(fn))
+}
+
+// scanLines reads body lines from r one at a time, parsing them into s.
+// If r holds the binary format, the records are decoded into s instead.
+func scanLines(r io.Reader, opts LoadOptions, s sink) (Rejects, error) {
+	r, opts = opts.track(r)
+	if dec := customDecoder(opts); dec != nil {
+		return decodeInto(dec, r, opts, s)
+	}
+	br, isBinary := sniffBinary(r)
+	if isBinary {
+		return scanBinary(br, opts, s)
+	}
+	var rejects Rejects
+	var long []byte
+	for lineNo := 1; ; lineNo++ {
+		line, err := readLine(br, &long)
+		if len(line) > 0 {
+			b, ok, perr := parseBodyLine(line, lineNo, opts)
+			if perr != nil {
+				if err := rejects.note(perr, opts); err != nil {
+					return Rejects{}, err
+				}
+			}
+			if ok {
+				s.addBody(b)
+			}
+		}
+		if err == io.EOF {
+			return rejects, nil
+		} else if err != nil {
+			return Rejects{}, err
+		}
+	}
+}
+
+// readLine reads one line, including its newline, from br. Lines that don't fit in the
+// reader's buffer are put together in long. Either way, the returned slice is only valid
+// until the next read.
+func readLine(br *bufio.Reader, long *[]byte) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	*long = append((*long)[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = br.ReadSlice('\n')
+		*long = append(*long, line...)
+	}
+	return *long, err
+}
+
+// A lineBatch is a run of consecutive lines, tagged with its position in the file.
+type lineBatch struct {
+	index int
+	lines [][]byte
+}
+
+// A pointBatch is the parsed result of the lineBatch with the same index.
+type pointBatch struct {
+	index   int
+	points  []MassPoint
+	rejects Rejects
+	err     *ParseError
+}
+
+// LoadConcurrent reads body lines from r and parses them across opts.Workers goroutines.
+//
+// Lines are handed out in numbered batches and the parsed batches are put back together
+// by number, so the points come back in the same order as the lines in the file,
+// however the workers happen to be scheduled. Binary input, and formats read by
+// opts.Decoder, are read by a single goroutine.
+func LoadConcurrent(r io.Reader, opts LoadOptions) ([]MassPoint, Rejects, error) {
+	r, opts = opts.track(r)
+	// Binary records don't need parsing, so there's nothing to gain from the workers.
+	// Neither do other decoders, which read their formats in a single pass.
+	br, isBinary := sniffBinary(r)
+	if isBinary || customDecoder(opts) != nil {
+		return Load(br, opts)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	batches := make(chan lineBatch, workers)
+	results := make(chan pointBatch, workers)
+	// done is closed to tell the reader to stop early, after a strict mode failure.
+	done := make(chan struct{})
+
+	// Start up the parsing workers, and close the results channel once they're all done.
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go parseBatches(batches, results, opts, &wg)
+	}
+	go func() { wg.Wait(); close(results) }()
+
+	// Reading happens in its own goroutine, so we can collect results while it runs.
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		readErr <- readBatches(br, batches, done)
+	}()
+
+	// Each batch goes into the slot matching its index, whatever order it arrives in.
+	// The reader sends batches in order, so once it stops every earlier batch has still
+	// been parsed, and the first failure we find below is the first one in the file.
+	var parsed []pointBatch
+	stopped := false
+	for b := range results {
+		for len(parsed) <= b.index {
+			parsed = append(parsed, pointBatch{})
+		}
+		parsed[b.index] = b
+		if b.err != nil && !stopped {
+			close(done)
+			stopped = true
+		}
+	}
+	if err := <-readErr; err != nil {
+		return nil, Rejects{}, err
+	}
+
+	total := 0
+	for _, b := range parsed {
+		if b.err != nil {
+			return nil, Rejects{}, b.err
+		}
+		total += len(b.points)
+	}
+
+	points := make([]MassPoint, 0, total)
+	var rejects Rejects
+	for _, b := range parsed {
+		points = append(points, b.points...)
+		rejects.merge(b.rejects, opts.MaxExamples)
+	}
+	return points, rejects, nil
+}
+
+// readBatches splits r into numbered batches of lines and sends them through the channel,
+// until it runs out of input or done is closed.
+func readBatches(r io.Reader, batches chan<- lineBatch, done <-chan struct{}) error {
+	br := bufio.NewReader(r)
+	batch := lineBatch{lines: make([][]byte, 0, batchSize)}
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			batch.lines = append(batch.lines, line)
+		}
+		if len(batch.lines) == batchSize || (err != nil && len(batch.lines) > 0) {
+			select {
+			case batches <- batch:
+			case <-done:
+				return nil
+			}
+			batch = lineBatch{index: batch.index + 1, lines: make([][]byte, 0, batchSize)}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// parseBatches is a loading worker. It parses each batch it receives and sends back the points.
+func parseBatches(batches <-chan lineBatch, results chan<- pointBatch, opts LoadOptions, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for batch := range batches {
+		points := pointSink{make([]MassPoint, 0, len(batch.lines))}
+		rejects, err := parseLines(batch.lines, batch.index*batchSize+1, opts, &points)
+		results <- pointBatch{batch.index, points.points, rejects, err}
+	}
+}
@@ -0,0 +1,66 @@
+// Package barycenter finds the center of mass of a system of bodies.
+// It holds the MassPoint math used by the linearBarycenter and concurrentBarycenter
+// commands, so other programs can import it rather than copying it.
+package barycenter
+
+// MassPoint is a point in 3-space plus mass information.
+// It's the primary datastructure we pass around when finding a barycenter.
+type MassPoint struct {
+	X, Y, Z, Mass float64
+}
+
+// AddMassPoints adds two mass points together.
+// It adds each coordinate, and the masses.
+func AddMassPoints(a MassPoint, b MassPoint) MassPoint {
+	return MassPoint{
+		a.X + b.X,
+		a.Y + b.Y,
+		a.Z + b.Z,
+		a.Mass + b.Mass,
+	}
+}
+
+// AvgMassPoints averages two mass points.
+// The coordinates are divided by two, but the masses are just added.
+func AvgMassPoints(a MassPoint, b MassPoint) MassPoint {
+	sum := AddMassPoints(a, b)
+	return MassPoint{
+		sum.X / 2,
+		sum.Y / 2,
+		sum.Z / 2,
+		sum.Mass,
+	}
+}
+
+// ToWeightedSubspace maps a mass point to a different point in space by its mass.
+func ToWeightedSubspace(a MassPoint) MassPoint {
+	return MassPoint{
+		a.X * a.Mass,
+		a.Y * a.Mass,
+		a.Z * a.Mass,
+		a.Mass,
+	}
+}
+
+// FromWeightedSubspace takes a mass point back out of the weighted subspace.
+func FromWeightedSubspace(a MassPoint) MassPoint {
+	return MassPoint{
+		a.X / a.Mass,
+		a.Y / a.Mass,
+		a.Z / a.Mass,
+		a.Mass,
+	}
+}
+
+// AvgMassPointsWeighted takes a pair of mass points and returns their weighted average.
+// In the weighted subspace the coordinates already carry the mass, so the pair is
+// summed rather than averaged; FromWeightedSubspace then divides by the total mass.
+//
+// A pair whose masses cancel out has no weighted average. That's why the strategies don't
+// chain it to reduce a whole system: they stay in the weighted subspace all the way up the
+// tree, and only divide by the system's total mass at the end.
+func AvgMassPointsWeighted(a MassPoint, b MassPoint) MassPoint {
+	aWeighted := ToWeightedSubspace(a)
+	bWeighted := ToWeightedSubspace(b)
+	return FromWeightedSubspace(AddMassPoints(aWeighted, bWeighted))
+}
@@ -0,0 +1,67 @@
+package barycenter
+
+import (
+	"bytes"
+	"io"
+)
+
+// The range loaders read regular files through a memory mapping when they can. Rather
+// than copying the file into a buffer a chunk at a time, and parsing it from there,
+// the operating system pages the file straight into our address space, and each worker
+// parses the lines of its byte range right where they are. Pipes, standard input and
+// compressed files can't be mapped, so they're still read through buffers, as they are
+// when LoadOptions.Buffered is set or the system can't map files.
+//
+// A mapped file has to hold still while it's being read: if another program truncates
+// it in the middle of a load, touching the missing pages kills the process.
+
+// A mappedFile is the contents of a file, mapped into memory.
+type mappedFile []byte
+
+// ReadAt implements io.ReaderAt, for the loaders that don't know about mappings.
+func (m mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// lines returns a function that reads the lines of m from start on, just like readLine
+// does, except that each line is a slice of the mapping itself rather than a copy.
+func (m mappedFile) lines(start int64) func() ([]byte, error) {
+	rest := []byte(m[start:])
+	return func() ([]byte, error) {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			line := rest
+			rest = nil
+			return line, io.EOF
+		}
+		line := rest[:i+1]
+		rest = rest[i+1:]
+		return line, nil
+	}
+}
+
+// ranges returns what the range loaders should read the input through: its mapping,
+// if the file can be mapped and opts doesn't say otherwise, or else the file itself.
+// The mapping lasts until the input is closed.
+func (in *input) ranges(opts LoadOptions) io.ReaderAt {
+	if in.mapped == nil && !opts.Buffered && in.size > 0 {
+		// If the file can't be mapped, reading it is slower but no less correct.
+		if data, err := mapFile(in.file, in.size); err == nil {
+			in.mapped = data
+		}
+	}
+	if in.mapped != nil {
+		return in.mapped
+	}
+	return in.file
+}
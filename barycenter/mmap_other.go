@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package barycenter
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile can't map files on this system, so the range loaders read them through buffers.
+func mapFile(f *os.File, size int64) (mappedFile, error) {
+	return nil, errors.New("barycenter: can't map files into memory on this system")
+}
+
+func unmapFile(m mappedFile) error { return nil }
@@ -0,0 +1,250 @@
+package barycenter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixedLines makes a text body file of n lines, each exactly 16 bytes long, so that
+// where the range boundaries fall is easy to work out.
+func fixedLines(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "1:2:3:%09d\n", i+1)
+	}
+	return buf.Bytes()
+}
+
+// withBadLines replaces every line of data whose number, counting from 1, is in lines
+// with one that doesn't parse, keeping the line the same length.
+func withBadLines(data []byte, lines ...int) []byte {
+	out := bytes.SplitAfter(append([]byte(nil), data...), []byte("\n"))
+	for _, n := range lines {
+		bad := bytes.Repeat([]byte("x"), len(out[n-1])-1)
+		out[n-1] = append(bad, '\n')
+	}
+	return bytes.Join(out, nil)
+}
+
+// rangeInputs are the text files the range loaders are checked against Load on.
+var rangeInputs = []struct {
+	name string
+	data []byte
+}{
+	{"empty", nil},
+	{"one line", []byte("1:2:3:4\n")},
+	{"no trailing newline", []byte("1:2:3:4\n5:6:7:8")},
+	// 2*minRangeSize bytes, so with two ranges the boundary falls right at the start of a line.
+	{"boundary on a line", fixedLines(2 * minRangeSize / 16)},
+	// A line longer, which moves the boundary to the middle of a line.
+	{"boundary in a line", append([]byte("1:2:3:4.0000001\n"), fixedLines(2*minRangeSize/16)...)},
+	// With a first line one byte longer again, the boundary falls on a line's newline.
+	{"boundary on a newline", append([]byte("1:2:3:4.00000001\n"), fixedLines(2*minRangeSize/16)...)},
+	{"straddling rejects", withBadLines(fixedLines(8*minRangeSize/16), 1, 4096, 4097, 4098, 16384, 32768)},
+	{"straddling rejects, no trailing newline", bytes.TrimSuffix(withBadLines(fixedLines(5*minRangeSize/16+3), 2, 8192, 20483), []byte("\n"))},
+}
+
+// Splitting a file into ranges, mapped or not, should give just what reading it
+// serially does, whatever the number of ranges and wherever their boundaries fall.
+func TestLoadRangesMatchesLoad(t *testing.T) {
+	for _, in := range rangeInputs {
+		opts := LoadOptions{Name: in.name, MaxExamples: 10}
+		want, wantRejects, err := Load(bytes.NewReader(in.data), opts)
+		if err != nil {
+			t.Fatalf("%s: %v", in.name, err)
+		}
+		for _, workers := range []int{1, 2, 3, 8} {
+			opts.Workers = workers
+			for _, r := range []struct {
+				name string
+				r    io.ReaderAt
+			}{
+				{"mapped", mappedFile(in.data)},
+				{"buffered", bytes.NewReader(in.data)},
+			} {
+				t.Run(fmt.Sprintf("%s/workers=%d/%s", in.name, workers, r.name), func(t *testing.T) {
+					got, rejects, err := LoadRanges(r.r, int64(len(in.data)), opts)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+						t.Errorf("got %d points, want %d", len(got), len(want))
+					}
+					if !reflect.DeepEqual(rejects, wantRejects) {
+						t.Errorf("got rejects %+v, want %+v", rejects, wantRejects)
+					}
+				})
+			}
+		}
+	}
+}
+
+// The boundary cases only test anything if they really are split into that many ranges.
+func TestRangeCount(t *testing.T) {
+	for _, tc := range []struct {
+		size    int64
+		workers int
+		want    int
+	}{
+		{0, 8, 1},
+		{minRangeSize - 1, 8, 1},
+		{2 * minRangeSize, 2, 2},
+		{2 * minRangeSize, 8, 2},
+		{8 * minRangeSize, 8, 8},
+		{8 * minRangeSize, 3, 3},
+	} {
+		if got := rangeCount(tc.size, tc.workers); got != tc.want {
+			t.Errorf("rangeCount(%d, %d) = %d, want %d", tc.size, tc.workers, got, tc.want)
+		}
+	}
+}
+
+// Strict mode should report the first bad line with the same number, wherever it is.
+func TestLoadRangesStrictLine(t *testing.T) {
+	data := withBadLines(fixedLines(8*minRangeSize/16), 4097, 20000)
+	for _, workers := range []int{1, 2, 8} {
+		_, _, err := LoadRanges(mappedFile(data), int64(len(data)), LoadOptions{Workers: workers, Strict: true})
+		perr, ok := err.(*ParseError)
+		if !ok || perr.Line != 4097 {
+			t.Errorf("workers=%d: got error %v, want one on line 4097", workers, err)
+		}
+	}
+}
+
+func TestMappedFileLines(t *testing.T) {
+	for _, tc := range []struct {
+		data  string
+		start int64
+		want  []string
+	}{
+		{"", 0, []string{""}},
+		{"a\n", 0, []string{"a\n", ""}},
+		{"a\nbc", 0, []string{"a\n", "bc"}},
+		{"a\nbc\n", 1, []string{"\n", "bc\n", ""}},
+		{"a\nbc\n", 5, []string{""}},
+	} {
+		next := mappedFile(tc.data).lines(tc.start)
+		var got []string
+		for {
+			line, err := next()
+			got = append(got, string(line))
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("lines(%q, %d) = %q, want %q", tc.data, tc.start, got, tc.want)
+		}
+	}
+}
+
+func TestMappedFileReadAt(t *testing.T) {
+	m := mappedFile("abcdef")
+	for _, tc := range []struct {
+		off     int64
+		size    int
+		want    string
+		wantEOF bool
+	}{
+		{0, 3, "abc", false},
+		{3, 3, "def", false},
+		{4, 3, "ef", true},
+		{6, 3, "", true},
+		{10, 3, "", true},
+	} {
+		p := make([]byte, tc.size)
+		n, err := m.ReadAt(p, tc.off)
+		if string(p[:n]) != tc.want || (err == io.EOF) != tc.wantEOF || (err != nil && err != io.EOF) {
+			t.Errorf("ReadAt(%d bytes at %d) = %q, %v", tc.size, tc.off, p[:n], err)
+		}
+	}
+	if _, err := m.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Error("ReadAt at a negative offset succeeded")
+	}
+}
+
+// LoadFile should give the same result whether it maps the file or not.
+func TestLoadFileMappedMatchesBuffered(t *testing.T) {
+	for _, in := range rangeInputs {
+		name := filepath.Join(t.TempDir(), "bodies.txt")
+		if err := os.WriteFile(name, in.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		opts := LoadOptions{Workers: 4, MaxExamples: 10}
+		want, wantRejects, err := LoadFile(name, LoadOptions{Workers: 4, MaxExamples: 10, Buffered: true})
+		if err != nil {
+			t.Fatalf("%s: %v", in.name, err)
+		}
+		got, rejects, err := LoadFile(name, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", in.name, err)
+		}
+		if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%s: mapped load got %d points, buffered got %d", in.name, len(got), len(want))
+		}
+		if !reflect.DeepEqual(rejects, wantRejects) {
+			t.Errorf("%s: mapped load got rejects %+v, buffered got %+v", in.name, rejects, wantRejects)
+		}
+	}
+}
+
+// loadBenchFile writes the body file the load benchmarks read: a million random bodies,
+// from the same seed every time, so both benchmarks load identical files.
+func loadBenchFile(b *testing.B, f Format) string {
+	b.Helper()
+	name := filepath.Join(b.TempDir(), "bodies")
+	file, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := WriteBodies(file, benchPoints(1000000), f); err != nil {
+		b.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return name
+}
+
+// benchmarkLoadFile times LoadFile on the benchmark file, in both formats, with opts.
+func benchmarkLoadFile(b *testing.B, opts LoadOptions) {
+	for _, f := range []struct {
+		name   string
+		format Format
+	}{{"text", Text}, {"binary", Binary}} {
+		name := loadBenchFile(b, f.format)
+		info, err := os.Stat(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, workers := range benchWorkers {
+			opts.Workers = workers
+			b.Run(fmt.Sprintf("%s/workers=%d", f.name, workers), func(b *testing.B) {
+				b.SetBytes(info.Size())
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := LoadFile(name, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkLoadMapped times LoadFile parsing the file in place through a memory mapping.
+func BenchmarkLoadMapped(b *testing.B) {
+	benchmarkLoadFile(b, LoadOptions{})
+}
+
+// BenchmarkLoadBuffered times LoadFile on the same file, read through buffers instead.
+func BenchmarkLoadBuffered(b *testing.B) {
+	benchmarkLoadFile(b, LoadOptions{Buffered: true})
+}
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package barycenter
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of f into memory, read-only.
+func mapFile(f *os.File, size int64) (mappedFile, error) {
+	if int64(int(size)) != size {
+		return nil, errors.New("barycenter: file is too big to map into memory")
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: f.Name(), Err: err}
+	}
+	return data, nil
+}
+
+// unmapFile undoes mapFile. The mapping mustn't be used afterwards.
+func unmapFile(m mappedFile) error {
+	return syscall.Munmap(m)
+}
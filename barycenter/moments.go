@@ -0,0 +1,190 @@
+package barycenter
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Moments describes how a system's mass is spread around its barycenter: its second mass
+// moments, and what can be read off them.
+type Moments struct {
+	// Barycenter is the virtual body at the barycenter, carrying the system's mass.
+	Barycenter MassPoint
+	// Variance is the mass-weighted variance of the positions along each axis.
+	Variance Vector
+	// Covariance is the mass-weighted covariance matrix of the positions, of which
+	// Variance is the diagonal.
+	Covariance [3][3]float64
+	// Inertia is the moment of inertia tensor about the barycenter.
+	Inertia [3][3]float64
+	// PrincipalMoments are the eigenvalues of Inertia, smallest first, and PrincipalAxes
+	// the unit eigenvectors that go with them: the axes the system spins most easily
+	// around, and least easily.
+	PrincipalMoments [3]float64
+	PrincipalAxes    [3]Vector
+	// RadiusOfGyration is the root mean square distance of the mass from the barycenter:
+	// the radius of a shell with the same mass and polar moment of inertia.
+	RadiusOfGyration float64
+}
+
+// A momentSum is a running mass-weighted mean and sum of squared deviations, updated in
+// one pass with Welford's method, weighted. Unlike summing m·x² and subtracting the
+// square of the mean, it doesn't lose every significant digit when the bodies sit far
+// from the origin, and two momentSums can be merged, so workers can each keep their own.
+type momentSum struct {
+	mass float64
+	mean [3]float64
+	// m2 is the sum of m·(r-mean)ᵢ·(r-mean)ⱼ over the points added.
+	m2 [3][3]float64
+}
+
+// add folds a point into the sums.
+func (s *momentSum) add(p MassPoint) {
+	r := [3]float64{p.X, p.Y, p.Z}
+	s.mass += p.Mass
+	if s.mass == 0 {
+		return
+	}
+	var before [3]float64
+	for i := range r {
+		before[i] = r[i] - s.mean[i]
+		s.mean[i] += before[i] * p.Mass / s.mass
+	}
+	for i := range r {
+		for j := range r {
+			s.m2[i][j] += p.Mass * before[i] * (r[j] - s.mean[j])
+		}
+	}
+}
+
+// merge folds another set of sums into this one.
+func (s *momentSum) merge(other momentSum) {
+	total := s.mass + other.mass
+	if total == 0 {
+		return
+	}
+	var delta [3]float64
+	for i := range delta {
+		delta[i] = other.mean[i] - s.mean[i]
+	}
+	for i := range delta {
+		for j := range delta {
+			s.m2[i][j] += other.m2[i][j] + delta[i]*delta[j]*s.mass*other.mass/total
+		}
+	}
+	for i := range delta {
+		s.mean[i] += delta[i] * other.mass / total
+	}
+	s.mass = total
+}
+
+// ComputeMoments finds the second mass moments of points about their barycenter,
+// splitting the points between workers goroutines that each keep their own running
+// sums. If workers is zero or less, GOMAXPROCS is used.
+func ComputeMoments(points []MassPoint, workers int) (Moments, error) {
+	if len(points) == 0 {
+		return Moments{}, ErrNoPoints
+	}
+	chunks := splitChunks(points, workers)
+	partials := make([]momentSum, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(s *momentSum, chunk []MassPoint) {
+			defer wg.Done()
+			for _, p := range chunk {
+				s.add(p)
+			}
+		}(&partials[i], chunk)
+	}
+	wg.Wait()
+
+	// The partials are merged in order, so the result doesn't depend on scheduling.
+	var sum momentSum
+	for _, partial := range partials {
+		sum.merge(partial)
+	}
+	return sum.moments()
+}
+
+// moments works out everything in Moments from the sums.
+func (s momentSum) moments() (Moments, error) {
+	b, err := checkResult(MassPoint{s.mean[0], s.mean[1], s.mean[2], s.mass})
+	if err != nil {
+		return Moments{}, err
+	}
+	m := Moments{Barycenter: b}
+	// Welford's update is only symmetric in exact arithmetic, so average out the rounding.
+	var m2 [3][3]float64
+	trace := 0.0
+	for i := range m2 {
+		for j := range m2[i] {
+			m2[i][j] = (s.m2[i][j] + s.m2[j][i]) / 2
+			m.Covariance[i][j] = m2[i][j] / s.mass
+		}
+		trace += m2[i][i]
+	}
+	m.Variance = Vector{m.Covariance[0][0], m.Covariance[1][1], m.Covariance[2][2]}
+	m.RadiusOfGyration = math.Sqrt(trace / s.mass)
+
+	// I = Σ m·(|r|²·δᵢⱼ - rᵢ·rⱼ), with r measured from the barycenter.
+	for i := range m2 {
+		for j := range m2[i] {
+			m.Inertia[i][j] = 0 - m2[i][j] // so zeros don't come out as -0
+		}
+		m.Inertia[i][i] += trace
+	}
+	m.PrincipalMoments, m.PrincipalAxes = symmetricEigen(m.Inertia)
+	return m, nil
+}
+
+// symmetricEigen finds the eigenvalues and unit eigenvectors of a symmetric 3×3 matrix
+// with the cyclic Jacobi method, which rotates away one off-diagonal element at a time
+// until there's nothing left but the diagonal. The eigenvalues come back smallest first.
+func symmetricEigen(a [3][3]float64) ([3]float64, [3]Vector) {
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off == 0 || off <= 1e-30*(a[0][0]*a[0][0]+a[1][1]*a[1][1]+a[2][2]*a[2][2]) {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				// Pick the rotation angle that zeroes a[p][q].
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+
+	order := []int{0, 1, 2}
+	sort.Slice(order, func(i, j int) bool { return a[order[i]][order[i]] < a[order[j]][order[j]] })
+	var values [3]float64
+	var vectors [3]Vector
+	for i, k := range order {
+		values[i] = a[k][k]
+		vectors[i] = Vector{v[0][k], v[1][k], v[2][k]}
+	}
+	return values, vectors
+}
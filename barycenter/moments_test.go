@@ -0,0 +1,145 @@
+package barycenter
+
+import (
+	"math"
+	"testing"
+)
+
+// sameAxis reports whether two unit vectors point along the same axis, either way.
+func sameAxis(a, b Vector) bool {
+	return closeTo(math.Abs(a.X*b.X+a.Y*b.Y+a.Z*b.Z), 1)
+}
+
+// Four unit masses: two at ±(1, 1, 0) and two at ±(0, 0, 1), all moved well away from
+// the origin. About their barycenter, the sums of m·rᵢ·rⱼ are 2 in xx, yy, xy and zz, so
+//
+//	    | 4 -2  0 |
+//	I = |-2  4  0 |
+//	    | 0  0  4 |
+//
+// whose principal moments are 2 about (1, 1, 0)/√2, 4 about z, and 6 about (1, -1, 0)/√2.
+func TestMomentsOfKnownSystem(t *testing.T) {
+	offset := Vector{100, -50, 7}
+	var points []MassPoint
+	for _, r := range []Vector{{1, 1, 0}, {-1, -1, 0}, {0, 0, 1}, {0, 0, -1}} {
+		points = append(points, MassPoint{r.X + offset.X, r.Y + offset.Y, r.Z + offset.Z, 1})
+	}
+	for _, workers := range []int{1, 2, 4} {
+		m, err := ComputeMoments(points, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !closeToPoint(m.Barycenter, MassPoint{offset.X, offset.Y, offset.Z, 4}) {
+			t.Errorf("%d workers: barycenter is %v", workers, m.Barycenter)
+		}
+		wantInertia := [3][3]float64{{4, -2, 0}, {-2, 4, 0}, {0, 0, 4}}
+		wantCovariance := [3][3]float64{{0.5, 0.5, 0}, {0.5, 0.5, 0}, {0, 0, 0.5}}
+		for i := range wantInertia {
+			for j := range wantInertia[i] {
+				if !closeTo(m.Inertia[i][j], wantInertia[i][j]) || !closeTo(m.Covariance[i][j], wantCovariance[i][j]) {
+					t.Errorf("%d workers: got inertia %v and covariance %v, want %v and %v",
+						workers, m.Inertia, m.Covariance, wantInertia, wantCovariance)
+				}
+			}
+		}
+		if !closeTo(m.Variance.X, 0.5) || !closeTo(m.Variance.Y, 0.5) || !closeTo(m.Variance.Z, 0.5) {
+			t.Errorf("%d workers: variance is %v, want 0.5 along each axis", workers, m.Variance)
+		}
+		if !closeTo(m.RadiusOfGyration, math.Sqrt(1.5)) {
+			t.Errorf("%d workers: radius of gyration is %g, want √1.5", workers, m.RadiusOfGyration)
+		}
+		wantMoments := [3]float64{2, 4, 6}
+		wantAxes := [3]Vector{{math.Sqrt2 / 2, math.Sqrt2 / 2, 0}, {0, 0, 1}, {math.Sqrt2 / 2, -math.Sqrt2 / 2, 0}}
+		for i := range wantMoments {
+			if !closeTo(m.PrincipalMoments[i], wantMoments[i]) || !sameAxis(m.PrincipalAxes[i], wantAxes[i]) {
+				t.Errorf("%d workers: principal moment %d is %g about %v, want %g about %v",
+					workers, i+1, m.PrincipalMoments[i], m.PrincipalAxes[i], wantMoments[i], wantAxes[i])
+			}
+		}
+	}
+}
+
+// The tridiagonal matrix with 2 on the diagonal and 1 beside it has eigenvalues 2-√2, 2
+// and 2+√2, with eigenvectors (1, -√2, 1)/2, (1, 0, -1)/√2 and (1, √2, 1)/2.
+func TestSymmetricEigen(t *testing.T) {
+	a := [3][3]float64{{2, 1, 0}, {1, 2, 1}, {0, 1, 2}}
+	values, vectors := symmetricEigen(a)
+	wantValues := [3]float64{2 - math.Sqrt2, 2, 2 + math.Sqrt2}
+	wantVectors := [3]Vector{{0.5, -math.Sqrt2 / 2, 0.5}, {math.Sqrt2 / 2, 0, -math.Sqrt2 / 2}, {0.5, math.Sqrt2 / 2, 0.5}}
+	for i := range values {
+		if !closeTo(values[i], wantValues[i]) || !sameAxis(vectors[i], wantVectors[i]) {
+			t.Errorf("eigenpair %d: got %g, %v, want %g, %v", i, values[i], vectors[i], wantValues[i], wantVectors[i])
+		}
+	}
+
+	// A diagonal matrix needs no rotating at all, just sorting.
+	values, vectors = symmetricEigen([3][3]float64{{3, 0, 0}, {0, 1, 0}, {0, 0, 2}})
+	if values != [3]float64{1, 2, 3} || vectors != [3]Vector{{0, 1, 0}, {0, 0, 1}, {1, 0, 0}} {
+		t.Errorf("diagonal matrix: got %v, %v", values, vectors)
+	}
+}
+
+// Merging the sums of each chunk should give what one pass over all the points does,
+// however the points are split, empty chunks and all.
+func TestMomentSumMerge(t *testing.T) {
+	points := mixedMassPoints(1000, 5)
+	var whole momentSum
+	for _, p := range points {
+		whole.add(p)
+	}
+	for _, cuts := range [][]int{
+		{0, 1000},
+		{0, 0, 500, 1000},
+		{0, 1, 2, 999, 1000},
+		{0, 137, 411, 412, 800, 1000, 1000},
+	} {
+		var merged momentSum
+		for i := 1; i < len(cuts); i++ {
+			var part momentSum
+			for _, p := range points[cuts[i-1]:cuts[i]] {
+				part.add(p)
+			}
+			merged.merge(part)
+		}
+		if !closeTo(merged.mass, whole.mass) {
+			t.Errorf("cuts %v: mass is %g, want %g", cuts, merged.mass, whole.mass)
+		}
+		for i := range whole.mean {
+			if !closeTo(merged.mean[i], whole.mean[i]) {
+				t.Errorf("cuts %v: mean is %v, want %v", cuts, merged.mean, whole.mean)
+			}
+			for j := range whole.m2[i] {
+				if math.Abs(merged.m2[i][j]-whole.m2[i][j]) > 1e-9*math.Abs(whole.m2[i][i]) {
+					t.Errorf("cuts %v: m2 is %v, want %v", cuts, merged.m2, whole.m2)
+				}
+			}
+		}
+	}
+
+	// And so ComputeMoments shouldn't depend on the number of workers.
+	want, err := ComputeMoments(points, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 3, 16} {
+		got, err := ComputeMoments(points, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want.PrincipalMoments {
+			if !closeTo(got.PrincipalMoments[i], want.PrincipalMoments[i]) || !sameAxis(got.PrincipalAxes[i], want.PrincipalAxes[i]) {
+				t.Errorf("%d workers: principal moment %d is %g about %v, want %g about %v", workers, i+1,
+					got.PrincipalMoments[i], got.PrincipalAxes[i], want.PrincipalMoments[i], want.PrincipalAxes[i])
+			}
+		}
+	}
+}
+
+func TestMomentsErrors(t *testing.T) {
+	if _, err := ComputeMoments(nil, 1); err != ErrNoPoints {
+		t.Errorf("no points: got error %v, want ErrNoPoints", err)
+	}
+	if _, err := ComputeMoments([]MassPoint{{1, 1, 1, 1}, {2, 2, 2, -1}}, 1); err == nil {
+		t.Error("masses that cancel out: got no error")
+	}
+}
@@ -0,0 +1,216 @@
+package barycenter
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// Points is a system of mass points in any number of dimensions, like 2 for map data or
+// dozens for feature vectors. Like Bodies, it keeps the masses in a column of their own;
+// the coordinates are packed together, point after point, Dim of them each.
+//
+// MassPoint is still the way to go in 3-space: it's what the loaders and the other
+// strategies work with, and ChunkedN hands 3-dimensional Points to the columnar
+// reduction rather than its own.
+type Points struct {
+	// Dim is the number of coordinates each point has.
+	Dim int
+	// Coords holds point i's coordinates in Coords[i*Dim:(i+1)*Dim].
+	Coords []float64
+	Mass   []float64
+}
+
+// NewPoints makes room for n points in dim dimensions, with none in it yet.
+func NewPoints(dim, n int) Points {
+	return Points{Dim: dim, Coords: make([]float64, 0, n*dim), Mass: make([]float64, 0, n)}
+}
+
+// PointsOf copies 3-dimensional mass points into Points.
+func PointsOf(points []MassPoint) Points {
+	p := NewPoints(3, len(points))
+	for _, mp := range points {
+		p.Coords = append(p.Coords, mp.X, mp.Y, mp.Z)
+		p.Mass = append(p.Mass, mp.Mass)
+	}
+	return p
+}
+
+// Len returns the number of points.
+func (p Points) Len() int { return len(p.Mass) }
+
+// At returns the coordinates of the i'th point. They're shared with p, not copied.
+func (p Points) At(i int) []float64 {
+	return p.Coords[i*p.Dim : (i+1)*p.Dim : (i+1)*p.Dim]
+}
+
+// Append adds a point to the end. It panics if coords doesn't have p.Dim coordinates.
+func (p *Points) Append(coords []float64, mass float64) {
+	if len(coords) != p.Dim {
+		panic(fmt.Sprintf("barycenter: appending a point with %d coordinates to Points with %d", len(coords), p.Dim))
+	}
+	p.Coords = append(p.Coords, coords...)
+	p.Mass = append(p.Mass, mass)
+}
+
+// Slice returns the points from lo up to hi, sharing their storage with p.
+func (p Points) Slice(lo, hi int) Points {
+	return Points{Dim: p.Dim, Coords: p.Coords[lo*p.Dim : hi*p.Dim], Mass: p.Mass[lo:hi]}
+}
+
+// clone copies p, so it can be reduced in place.
+func (p Points) clone() Points {
+	return Points{
+		Dim:    p.Dim,
+		Coords: append([]float64(nil), p.Coords...),
+		Mass:   append([]float64(nil), p.Mass...),
+	}
+}
+
+// A Centroid is the virtual point at the barycenter of Points, carrying the system's mass.
+type Centroid struct {
+	Coords []float64
+	Mass   float64
+}
+
+// checkCentroid is checkResult for any number of dimensions.
+func checkCentroid(c Centroid) (Centroid, error) {
+	if c.Mass == 0 {
+		return Centroid{}, ErrZeroMass
+	}
+	if math.IsNaN(c.Mass) || math.IsInf(c.Mass, 0) {
+		return Centroid{}, ErrNotFinite
+	}
+	for _, v := range c.Coords {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Centroid{}, ErrNotFinite
+		}
+	}
+	return c, nil
+}
+
+// A StrategyN is a Strategy for Points in any number of dimensions. Like Strategies,
+// they never modify the points they're given, and return ErrZeroMass rather than a
+// barycenter made of NaNs.
+type StrategyN interface {
+	ComputeN(points Points) (Centroid, error)
+}
+
+// LinearN is the Linear strategy in any number of dimensions. It maps a copy of the points
+// into the weighted subspace, sums each coordinate pairwise on a single goroutine, and
+// divides by the total mass at the end.
+type LinearN struct{}
+
+// ComputeN implements StrategyN.
+func (LinearN) ComputeN(points Points) (Centroid, error) {
+	if points.Len() == 0 {
+		return Centroid{}, ErrNoPoints
+	}
+	buf := points.clone()
+	weighPoints(buf)
+	return fromWeightedCentroid(sumPointsInPlace(buf))
+}
+
+// weighPoints maps points into the weighted subspace, in place.
+func weighPoints(points Points) {
+	for i, mass := range points.Mass {
+		c := points.At(i)
+		for k := range c {
+			c[k] *= mass
+		}
+	}
+}
+
+// sumPointsInPlace is reduceColumns for Points: it sums each coordinate of points, and
+// their masses, pairwise with sumPairwise, leaving points scrambled. The pairs are the same
+// ones the MassPoint strategies add up, so in three dimensions the results are bit-for-bit
+// the same as theirs.
+func sumPointsInPlace(points Points) Centroid {
+	c := Centroid{Coords: make([]float64, points.Dim)}
+	for k := range c.Coords {
+		c.Coords[k] = sumPairwise(points.Coords[k:], points.Dim)
+	}
+	c.Mass = sumPairwise(points.Mass, 1)
+	return c
+}
+
+// fromWeightedCentroid takes a weighted sum back out of the weighted subspace, and checks
+// that there's a barycenter to be had.
+func fromWeightedCentroid(c Centroid) (Centroid, error) {
+	for k := range c.Coords {
+		c.Coords[k] /= c.Mass
+	}
+	return checkCentroid(c)
+}
+
+// ChunkedN is the Chunked strategy in any number of dimensions: it splits the points into
+// one chunk per worker, reduces each chunk in place in its own goroutine, and then combines
+// the partial results. Its results are bit-for-bit the same as LinearN's.
+//
+// Three dimensions are common enough to get a fast path of their own: those points are
+// handed to the columnar reduction Bodies uses, whose loops know there are three coordinates.
+type ChunkedN struct {
+	// Workers is the number of goroutines to reduce with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+}
+
+// ComputeN implements StrategyN.
+func (s ChunkedN) ComputeN(points Points) (Centroid, error) {
+	n := points.Len()
+	if n == 0 {
+		return Centroid{}, ErrNoPoints
+	}
+	if points.Dim == 3 {
+		return computeColumns3(points, s.Workers)
+	}
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	size := chunkSize(n, workers)
+
+	// We reduce in place, so work on a copy rather than the caller's points.
+	buf := points.clone()
+	partials := NewPoints(points.Dim, (n+size-1)/size)
+	partials.Coords = partials.Coords[:cap(partials.Coords)]
+	partials.Mass = partials.Mass[:cap(partials.Mass)]
+	var wg sync.WaitGroup
+	for i := 0; i < partials.Len(); i++ {
+		lo := i * size
+		hi := lo + size
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(i int, chunk Points) {
+			defer wg.Done()
+			weighPoints(chunk)
+			c := sumPointsInPlace(chunk)
+			copy(partials.At(i), c.Coords)
+			partials.Mass[i] = c.Mass
+		}(i, buf.Slice(lo, hi))
+	}
+	wg.Wait()
+
+	// There's at most one partial per worker, so combining them is cheap.
+	return fromWeightedCentroid(sumPointsInPlace(partials))
+}
+
+// computeColumns3 reduces 3-dimensional Points as columns.
+func computeColumns3(points Points, workers int) (Centroid, error) {
+	n := points.Len()
+	all := make([]float64, 4*n)
+	b := Bodies{all[:n:n], all[n : 2*n : 2*n], all[2*n : 3*n : 3*n], all[3*n:]}
+	for i := 0; i < n; i++ {
+		b.X[i], b.Y[i], b.Z[i] = points.Coords[3*i], points.Coords[3*i+1], points.Coords[3*i+2]
+	}
+	copy(b.Mass, points.Mass)
+	p, err := b.Reduce(workers)
+	if err != nil {
+		return Centroid{}, err
+	}
+	return Centroid{[]float64{p.X, p.Y, p.Z}, p.Mass}, nil
+}
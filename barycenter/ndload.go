@@ -0,0 +1,198 @@
+package barycenter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// The N-dimensional body format is the text format with any number of coordinates:
+// each line holds a point's coordinates and then its mass, separated by colons, as in
+// x1:x2:...:xN:mass. There are no velocities or labels.
+//
+// A file can declare its dimension in a header line before the first point:
+//
+//	# dim 2
+//
+// Otherwise the dimension is taken from the first point, as one less than its number of
+// fields. Lines starting with # are comments, and are skipped like blank lines.
+// Binary body files can be read as N-dimensional files too, with 3 dimensions.
+
+// MaxDim is the largest dimension a body file can have.
+const MaxDim = 1 << 10
+
+// dimHeader starts the header line that declares a file's dimension.
+var dimHeader = []byte("dim")
+
+// parseDimHeader reads the dimension from a comment line, if it's a dimension header.
+func parseDimHeader(comment []byte) (int, bool, error) {
+	fields := bytes.Fields(bytes.TrimPrefix(comment, []byte("#")))
+	if len(fields) == 0 || !bytes.Equal(fields[0], dimHeader) {
+		return 0, false, nil
+	}
+	if len(fields) != 2 {
+		return 0, true, errors.New("malformed dimension header")
+	}
+	dim, err := strconv.Atoi(string(fields[1]))
+	if err != nil || dim < 1 || dim > MaxDim {
+		return 0, true, fmt.Errorf("bad dimension %q", fields[1])
+	}
+	return dim, true, nil
+}
+
+// countFields counts the colon-separated fields on a line.
+func countFields(line []byte) int {
+	return bytes.Count(line, []byte{':'}) + 1
+}
+
+// parsePointLine parses a line of dim coordinates and a mass into coords, and returns the mass.
+// If the line is malformed, the error says which column is at fault.
+func parsePointLine(line []byte, coords []float64) (float64, *ParseError) {
+	dim := len(coords)
+	if n := countFields(line); n != dim+1 {
+		return 0, &ParseError{Column: 1, Err: fmt.Errorf("%d fields, but points in %d dimensions have %d", n, dim, dim+1)}
+	}
+	start := 0
+	var mass float64
+	for i := 0; i <= dim; i++ {
+		end := len(line)
+		if j := bytes.IndexByte(line[start:], ':'); j >= 0 {
+			end = start + j
+		}
+		v, err := parseFloat(bytes.TrimSpace(line[start:end]))
+		if err != nil {
+			name := "mass"
+			if i < dim {
+				name = fmt.Sprintf("x%d", i+1)
+			}
+			return 0, &ParseError{Column: start + 1, Err: fmt.Errorf("invalid %s value %q", name, line[start:end])}
+		}
+		if i < dim {
+			coords[i] = v
+		} else {
+			mass = v
+		}
+		start = end + 1
+	}
+	return mass, nil
+}
+
+// checkPoint applies the validation policy to a point that parsed, like checkBody does.
+// The column is left for the caller to fill in.
+func checkPoint(coords []float64, mass float64, v Validation) (int, *ParseError) {
+	if v == AcceptInvalid {
+		return 0, nil
+	}
+	var err error
+	field := 0
+	for i, c := range coords {
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			field, err = i, fmt.Errorf("x%d value %v is not finite", i+1, c)
+			break
+		}
+	}
+	switch {
+	case err != nil:
+	case math.IsNaN(mass) || math.IsInf(mass, 0):
+		field, err = len(coords), fmt.Errorf("mass value %v is not finite", mass)
+	case mass <= 0:
+		field, err = len(coords), fmt.Errorf("mass %v is not positive", mass)
+	default:
+		return 0, nil
+	}
+	return field, &ParseError{Err: err, flagged: v == FlagInvalid}
+}
+
+// LoadPoints reads N-dimensional body lines from r. If dim is zero, the dimension comes
+// from the file's header, or else its first point; otherwise, the file must have dim
+// coordinates per point, and if it declares a dimension, it must be the same one.
+// Malformed lines are handled as opts says, just as the other loaders do, and reading stops
+// once opts.Context is done.
+func LoadPoints(r io.Reader, dim int, opts LoadOptions) (Points, Rejects, error) {
+	r, opts = opts.track(r)
+	// The binary format is 3-dimensional, and it's read like any other binary file.
+	br, isBinary := sniffBinary(r)
+	if isBinary {
+		if dim != 0 && dim != 3 {
+			return Points{}, Rejects{}, fmt.Errorf("barycenter: binary body files have 3 dimensions, not %d", dim)
+		}
+		var mps pointSink
+		rejects, err := scanBinary(br, opts, &mps)
+		if err != nil {
+			return Points{}, Rejects{}, err
+		}
+		return PointsOf(mps.points), rejects, nil
+	}
+
+	var points Points
+	var coords []float64
+	var rejects Rejects
+	var long []byte
+	for lineNo := 1; ; lineNo++ {
+		line, err := readLine(br, &long)
+		if err != nil && err != io.EOF {
+			return Points{}, Rejects{}, err
+		}
+		text := bytes.TrimRight(line, "\r\n")
+		trimmed := bytes.TrimSpace(text)
+
+		switch {
+		case len(trimmed) == 0:
+		case trimmed[0] == '#':
+			// Only a header before the first point says anything.
+			declared, isHeader, herr := parseDimHeader(trimmed)
+			if !isHeader || coords != nil {
+				break
+			}
+			if herr != nil {
+				return Points{}, Rejects{}, &ParseError{Name: opts.Name, Line: lineNo, Column: 1, Text: string(text), Err: herr}
+			}
+			if dim != 0 && declared != dim {
+				return Points{}, Rejects{}, &ParseError{Name: opts.Name, Line: lineNo, Column: 1, Text: string(text),
+					Err: fmt.Errorf("file has %d dimensions, not %d", declared, dim)}
+			}
+			dim = declared
+		default:
+			if coords == nil {
+				if dim == 0 {
+					dim = countFields(trimmed) - 1
+				}
+				if dim < 1 || dim > MaxDim {
+					return Points{}, Rejects{}, &ParseError{Name: opts.Name, Line: lineNo, Column: 1, Text: string(text),
+						Err: fmt.Errorf("can't tell the dimension from %d fields", dim+1)}
+				}
+				coords = make([]float64, dim)
+				points = NewPoints(dim, 0)
+			}
+			mass, perr := parsePointLine(text, coords)
+			if perr == nil {
+				var field int
+				if field, perr = checkPoint(coords, mass, opts.Validation); perr != nil {
+					perr.Column = fieldColumn(text, field)
+				}
+			}
+			if perr != nil {
+				perr.Name, perr.Line, perr.Text = opts.Name, lineNo, string(text)
+				keep := perr.flagged
+				if perr = rejects.note(perr, opts); perr != nil {
+					return Points{}, Rejects{}, perr
+				}
+				if !keep {
+					break
+				}
+			}
+			points.Append(coords, mass)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	if coords == nil && dim != 0 {
+		points = NewPoints(dim, 0)
+	}
+	return points, rejects, nil
+}
@@ -0,0 +1,270 @@
+package barycenter
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+// maxOctreeDepth stops bodies that sit on top of each other from splitting the tree forever.
+// Past this depth, whatever bodies are left share a leaf.
+const maxOctreeDepth = 48
+
+// An Octree is a Barnes-Hut tree over a set of bodies. Each node is a cube of space split
+// into eight octants, and stores the barycenter of every body inside it, summed in the
+// weighted subspace just like the barycenter of the whole system. From far enough
+// away, the pull of a whole node can be taken as the pull of that one virtual body,
+// which brings the cost of finding every body's acceleration down from O(n²) to O(n log n).
+type Octree struct {
+	bodies []MassPoint
+	root   *octNode
+}
+
+// An octNode is one cube of an Octree.
+type octNode struct {
+	center [3]float64
+	half   float64 // half the length of a side
+	// mass is the barycenter of the bodies in the cube, carrying their total mass.
+	// If their masses cancel out, there's no barycenter, and the node is always opened.
+	mass MassPoint
+	// weighted is the sum of the bodies in the weighted subspace, which the parent sums in turn.
+	weighted MassPoint
+	children [8]*octNode
+	// leaf holds the indices of the bodies in a cube that wasn't split, which is usually just one.
+	leaf []int
+}
+
+// BuildOctree builds an octree over bodies, building the eight subtrees under each
+// of the top few nodes concurrently, with up to workers goroutines at once.
+// If workers is zero or less, GOMAXPROCS is used. The tree keeps a reference to bodies,
+// so they shouldn't be changed while it's in use.
+func BuildOctree(bodies []MassPoint, workers int) *Octree {
+	t := &Octree{bodies: bodies}
+	if len(bodies) == 0 {
+		return t
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// The root is the smallest cube around all the bodies.
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range bodies {
+		for axis, v := range [3]float64{p.X, p.Y, p.Z} {
+			lo[axis] = math.Min(lo[axis], v)
+			hi[axis] = math.Max(hi[axis], v)
+		}
+	}
+	var center [3]float64
+	half := 0.0
+	for axis := range center {
+		center[axis] = (lo[axis] + hi[axis]) / 2
+		half = math.Max(half, (hi[axis]-lo[axis])/2)
+	}
+	// Bodies on the far faces of the cube still have to land inside it.
+	half = half*(1+1e-9) + math.SmallestNonzeroFloat64
+
+	index := make([]int, len(bodies))
+	for i := range index {
+		index[i] = i
+	}
+	// Going one level deeper multiplies the subtrees by eight, so we only need to fan out
+	// until there's a subtree per worker.
+	parallelDepth := 0
+	for n := 1; n < workers; n *= 8 {
+		parallelDepth++
+	}
+	t.root = t.build(index, make([]int, len(index)), center, half, 0, parallelDepth)
+	return t
+}
+
+// build builds the node for the cube around center holding the bodies in index.
+// scratch is the same length as index, for sorting the bodies into octants.
+// Nodes shallower than parallelDepth build their children in their own goroutines.
+func (t *Octree) build(index, scratch []int, center [3]float64, half float64, depth, parallelDepth int) *octNode {
+	n := &octNode{center: center, half: half}
+	if len(index) == 1 || depth == maxOctreeDepth {
+		n.leaf = index
+		for _, i := range index {
+			n.weighted = AddMassPoints(n.weighted, ToWeightedSubspace(t.bodies[i]))
+		}
+		n.mass = FromWeightedSubspace(n.weighted)
+		return n
+	}
+
+	// Sort the bodies into octants, by way of scratch. Each child gets its own part of
+	// index and scratch, so the allocations come down to the nodes themselves.
+	var counts [8]int
+	for _, i := range index {
+		counts[n.octant(t.bodies[i])]++
+	}
+	var starts [8]int
+	for o := 1; o < 8; o++ {
+		starts[o] = starts[o-1] + counts[o-1]
+	}
+	next := starts
+	for _, i := range index {
+		o := n.octant(t.bodies[i])
+		scratch[next[o]] = i
+		next[o]++
+	}
+	copy(index, scratch)
+
+	var wg sync.WaitGroup
+	for o := range n.children {
+		if counts[o] == 0 {
+			continue
+		}
+		lo, hi := starts[o], starts[o]+counts[o]
+		c := n.childCenter(o)
+		if depth < parallelDepth {
+			wg.Add(1)
+			go func(o int) {
+				defer wg.Done()
+				n.children[o] = t.build(index[lo:hi], scratch[lo:hi], c, half/2, depth+1, parallelDepth)
+			}(o)
+		} else {
+			n.children[o] = t.build(index[lo:hi], scratch[lo:hi], c, half/2, depth+1, parallelDepth)
+		}
+	}
+	wg.Wait()
+
+	// The node's barycenter is the weighted average of its children's.
+	for _, c := range n.children {
+		if c != nil {
+			n.weighted = AddMassPoints(n.weighted, c.weighted)
+		}
+	}
+	n.mass = FromWeightedSubspace(n.weighted)
+	return n
+}
+
+// octant says which of the node's eight octants p falls in: bit 0 is set for the
+// upper half in x, bit 1 in y and bit 2 in z.
+func (n *octNode) octant(p MassPoint) int {
+	o := 0
+	if p.X >= n.center[0] {
+		o |= 1
+	}
+	if p.Y >= n.center[1] {
+		o |= 2
+	}
+	if p.Z >= n.center[2] {
+		o |= 4
+	}
+	return o
+}
+
+// childCenter finds the center of octant o.
+func (n *octNode) childCenter(o int) [3]float64 {
+	c := n.center
+	q := n.half / 2
+	for axis := range c {
+		if o&(1<<uint(axis)) != 0 {
+			c[axis] += q
+		} else {
+			c[axis] -= q
+		}
+	}
+	return c
+}
+
+// contains reports whether p is inside the node's cube.
+func (n *octNode) contains(p MassPoint) bool {
+	return math.Abs(p.X-n.center[0]) <= n.half &&
+		math.Abs(p.Y-n.center[1]) <= n.half &&
+		math.Abs(p.Z-n.center[2]) <= n.half
+}
+
+// Barycenter returns the barycenter of all the bodies, which the root node already has.
+func (t *Octree) Barycenter() (MassPoint, error) {
+	if t.root == nil {
+		return MassPoint{}, ErrNoPoints
+	}
+	return checkResult(t.root.mass)
+}
+
+// Gravity sets out how accelerations are worked out from an Octree.
+type Gravity struct {
+	// G is the gravitational constant, in whatever units the bodies use.
+	G float64
+	// Theta is the Barnes-Hut opening angle. A node is taken as a single body when its
+	// width over its distance is less than Theta; zero opens every node, which is the
+	// same as summing over every pair of bodies.
+	Theta float64
+	// Softening is added in quadrature to every distance, so close encounters
+	// (and bodies sitting on top of each other) don't blow up.
+	Softening float64
+}
+
+// Accelerations works out the acceleration of every body in the tree, splitting the bodies
+// between workers goroutines. If workers is zero or less, GOMAXPROCS is used.
+// If acc is big enough it's reused, and otherwise a new slice is allocated.
+func (t *Octree) Accelerations(g Gravity, workers int, acc []Vector) []Vector {
+	if cap(acc) >= len(t.bodies) {
+		acc = acc[:len(t.bodies)]
+	} else {
+		acc = make([]Vector, len(t.bodies))
+	}
+	if t.root == nil {
+		return acc
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo := len(t.bodies) * w / workers
+		hi := len(t.bodies) * (w + 1) / workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				acc[i] = t.acceleration(t.root, i, g)
+			}
+		}()
+	}
+	wg.Wait()
+	return acc
+}
+
+// acceleration works out the pull of the bodies under n on body i.
+func (t *Octree) acceleration(n *octNode, i int, g Gravity) Vector {
+	p := t.bodies[i]
+	if n.leaf != nil {
+		var a Vector
+		for _, j := range n.leaf {
+			if j != i {
+				a = a.add(pull(p, t.bodies[j], g))
+			}
+		}
+		return a
+	}
+
+	// A node far enough away, and not around the body itself, acts as a single body.
+	dx, dy, dz := n.mass.X-p.X, n.mass.Y-p.Y, n.mass.Z-p.Z
+	dist := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if isFinite(n.mass) && 2*n.half < g.Theta*dist && !n.contains(p) {
+		return pull(p, n.mass, g)
+	}
+	var a Vector
+	for _, c := range n.children {
+		if c != nil {
+			a = a.add(t.acceleration(c, i, g))
+		}
+	}
+	return a
+}
+
+// pull is the acceleration of p towards the body q.
+func pull(p, q MassPoint, g Gravity) Vector {
+	dx, dy, dz := q.X-p.X, q.Y-p.Y, q.Z-p.Z
+	r2 := dx*dx + dy*dy + dz*dz + g.Softening*g.Softening
+	if r2 == 0 {
+		return Vector{}
+	}
+	f := g.G * q.Mass / (r2 * math.Sqrt(r2))
+	return Vector{dx * f, dy * f, dz * f}
+}
@@ -0,0 +1,108 @@
+package barycenter
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomBodies makes n bodies as genBodies would, moving at up to velMax in each axis.
+func randomBodies(n int, seed int64, velMax int) []Body {
+	rng := rand.New(rand.NewSource(seed))
+	bodies := make([]Body, n)
+	for i := range bodies {
+		bodies[i] = RandomBody(rng, velMax)
+	}
+	return bodies
+}
+
+// octreeInputs are the systems the octree is checked on.
+var octreeInputs = []struct {
+	name   string
+	points []MassPoint
+}{
+	{"single body", []MassPoint{{1, 2, 3, 4}}},
+	{"pair", []MassPoint{{0, 0, 0, 1}, {4, 4, 4, 3}}},
+	// Bodies on top of each other can't be split, so they share a leaf at the bottom.
+	{"duplicates", []MassPoint{{1, 1, 1, 1}, {1, 1, 1, 2}, {1, 1, 1, 3}, {5, 5, 5, 1}}},
+	{"cancelling masses", []MassPoint{{1, 1, 1, 1}, {2, 2, 2, -1}, {3, 3, 3, 3}}},
+	{"random", RandomMassPoints(5000, 1)},
+}
+
+func TestOctreeBarycenterMatchesLinear(t *testing.T) {
+	for _, in := range octreeInputs {
+		want, err := Linear{}.Compute(in.points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 3, 8, 64} {
+			got, err := BuildOctree(in.points, workers).Barycenter()
+			if err != nil {
+				t.Fatalf("%s, %d workers: %v", in.name, workers, err)
+			}
+			if !closeToPoint(got, want) {
+				t.Errorf("%s, %d workers: got %v, want %v", in.name, workers, got, want)
+			}
+		}
+	}
+	if _, err := BuildOctree(nil, 1).Barycenter(); err != ErrNoPoints {
+		t.Errorf("empty tree: got error %v, want ErrNoPoints", err)
+	}
+}
+
+// directAccelerations sums the pull of every other body on each body, the O(n²) way.
+// It also returns the sum of the sizes of the pulls, to judge the rounding by.
+func directAccelerations(points []MassPoint, g Gravity) ([]Vector, []float64) {
+	acc := make([]Vector, len(points))
+	scale := make([]float64, len(points))
+	for i, p := range points {
+		for j, q := range points {
+			if i != j {
+				a := pull(p, q, g)
+				acc[i] = acc[i].add(a)
+				scale[i] += math.Abs(a.X) + math.Abs(a.Y) + math.Abs(a.Z)
+			}
+		}
+	}
+	return acc, scale
+}
+
+// With theta zero, every node is opened, so the tree should give the direct sums, give
+// or take the order they're added up in.
+func TestOctreeThetaZeroMatchesDirect(t *testing.T) {
+	for _, in := range octreeInputs[:4] {
+		testAccelerations(t, in.name, in.points, Gravity{G: 1, Theta: 0, Softening: 0.01}, 1e-12)
+	}
+	testAccelerations(t, "random", RandomMassPoints(500, 2), Gravity{G: 1, Theta: 0, Softening: 0.01}, 1e-12)
+	// A positive theta approximates far nodes, within a few percent.
+	testAccelerations(t, "random, theta 0.5", RandomMassPoints(500, 2), Gravity{G: 1, Theta: 0.5, Softening: 0.01}, 5e-2)
+}
+
+func testAccelerations(t *testing.T, name string, points []MassPoint, g Gravity, tolerance float64) {
+	t.Helper()
+	want, scale := directAccelerations(points, g)
+	for _, workers := range []int{1, 4} {
+		got := BuildOctree(points, workers).Accelerations(g, workers, nil)
+		for i := range want {
+			d := math.Abs(got[i].X-want[i].X) + math.Abs(got[i].Y-want[i].Y) + math.Abs(got[i].Z-want[i].Z)
+			if d > tolerance*scale[i] {
+				t.Errorf("%s, %d workers: body %d: got %v, want %v", name, workers, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+// Accelerations should reuse a slice that's big enough.
+func TestOctreeAccelerationsReuse(t *testing.T) {
+	points := RandomMassPoints(100, 3)
+	tree := BuildOctree(points, 2)
+	buf := make([]Vector, 0, 200)
+	acc := tree.Accelerations(Gravity{G: 1, Theta: 0.5}, 2, buf)
+	if len(acc) != len(points) || &acc[0] != &buf[:1][0] {
+		t.Errorf("got %d accelerations in a new slice, want %d in the one passed in", len(acc), len(points))
+	}
+	if got := BuildOctree(nil, 1).Accelerations(Gravity{G: 1}, 1, nil); len(got) != 0 {
+		t.Errorf("empty tree: got accelerations %v", got)
+	}
+}
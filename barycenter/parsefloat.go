@@ -0,0 +1,89 @@
+package barycenter
+
+import "strconv"
+
+// float64pow10 holds the powers of ten that are exactly representable as a float64.
+var float64pow10 = [...]float64{
+	1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9,
+	1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19,
+	1e20, 1e21, 1e22,
+}
+
+// parseFloat parses a decimal number such as 12, -3.5 or 6.02e23 from b, without
+// allocating. Body files are mostly short integers and decimals, and for those the
+// digits and the power of ten both fit exactly in a float64, so a single multiply
+// or divide gives the correctly rounded result.
+// Anything else (long mantissas, big exponents, Inf, NaN, or garbage) is handed
+// to strconv.ParseFloat, which also produces the error for malformed numbers.
+func parseFloat(b []byte) (float64, error) {
+	i := 0
+	neg := false
+	if i < len(b) && (b[i] == '+' || b[i] == '-') {
+		neg = b[i] == '-'
+		i++
+	}
+
+	var mant uint64
+	exp := 0
+	sawDigits := false
+	for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+		if mant >= 1<<59 {
+			return strconv.ParseFloat(string(b), 64)
+		}
+		mant = mant*10 + uint64(b[i]-'0')
+		sawDigits = true
+	}
+	if i < len(b) && b[i] == '.' {
+		for i++; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+			if mant >= 1<<59 {
+				return strconv.ParseFloat(string(b), 64)
+			}
+			mant = mant*10 + uint64(b[i]-'0')
+			exp--
+			sawDigits = true
+		}
+	}
+	if !sawDigits {
+		return strconv.ParseFloat(string(b), 64)
+	}
+
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		expNeg := false
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			expNeg = b[i] == '-'
+			i++
+		}
+		e := 0
+		expStart := i
+		for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+			if e > 1000 {
+				return strconv.ParseFloat(string(b), 64)
+			}
+			e = e*10 + int(b[i]-'0')
+		}
+		if i == expStart {
+			return strconv.ParseFloat(string(b), 64)
+		}
+		if expNeg {
+			e = -e
+		}
+		exp += e
+	}
+
+	// Trailing junk, or a number that can't be converted exactly with one operation.
+	if i != len(b) || mant > 1<<53 || exp < -22 || exp > 22 {
+		return strconv.ParseFloat(string(b), 64)
+	}
+
+	f := float64(mant)
+	if exp < 0 {
+		f /= float64pow10[-exp]
+	} else {
+		f *= float64pow10[exp]
+	}
+	if neg {
+		f = -f
+	}
+	return f, nil
+}
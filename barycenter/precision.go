@@ -0,0 +1,315 @@
+package barycenter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+)
+
+// ErrNotFinite is returned when a barycenter can't be found because of NaN or infinite
+// values. The exact strategy returns it for any such point, since big.Float can't represent them.
+var ErrNotFinite = errors.New("barycenter: coordinates and masses must be finite")
+
+// Precision selects how carefully a barycenter is summed.
+type Precision int
+
+const (
+	// Pairwise is the pairwise tree of sums in the weighted subspace used by Linear and
+	// Chunked. Every level of the tree rounds an add, so rounding errors build up with
+	// the depth of the tree.
+	Pairwise Precision = iota
+	// Compensated sums the weighted subspace once, with Neumaier compensated sums.
+	Compensated
+	// Exact sums the weighted subspace exactly with math/big, and rounds once at the end.
+	// It's much slower, and is meant as a reference.
+	Exact
+)
+
+var precisionNames = [...]string{"pairwise", "compensated", "exact"}
+
+func (p Precision) String() string {
+	if p < 0 || int(p) >= len(precisionNames) {
+		return fmt.Sprintf("Precision(%d)", int(p))
+	}
+	return precisionNames[p]
+}
+
+// ParsePrecision looks up a precision by name: pairwise, compensated or exact.
+func ParsePrecision(name string) (Precision, error) {
+	for i, n := range precisionNames {
+		if n == name {
+			return Precision(i), nil
+		}
+	}
+	return 0, fmt.Errorf("barycenter: unknown precision %q", name)
+}
+
+// Strategy returns a strategy that computes barycenters at this precision with
+// the given number of workers.
+func (p Precision) Strategy(workers int) Strategy {
+	switch p {
+	case Compensated:
+		return CompensatedSum{Workers: workers}
+	case Exact:
+		return ExactSum{Workers: workers}
+	}
+	return Chunked{Workers: workers}
+}
+
+// neumaierAdd adds x to the running sum *s, and adds the low-order bits lost to
+// rounding to the compensation term *c.
+func neumaierAdd(s, c *float64, x float64) {
+	t := *s + x
+	if math.Abs(*s) >= math.Abs(x) {
+		*c += (*s - t) + x
+	} else {
+		*c += (x - t) + *s
+	}
+	*s = t
+}
+
+// splitChunks splits points into at most workers chunks of roughly equal size.
+func splitChunks(points []MassPoint, workers int) [][]MassPoint {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(points) {
+		workers = len(points)
+	}
+	chunks := make([][]MassPoint, workers)
+	for i := range chunks {
+		chunks[i] = points[len(points)*i/workers : len(points)*(i+1)/workers]
+	}
+	return chunks
+}
+
+// CompensatedSum is the strategy for the Compensated precision. Each worker folds
+// its chunk of the points into a WeightedSum, and the partial sums are merged in order.
+type CompensatedSum struct {
+	// Workers is the number of goroutines to sum with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+}
+
+// Compute implements Strategy.
+func (s CompensatedSum) Compute(points []MassPoint) (MassPoint, error) {
+	return s.computeContext(context.Background(), points)
+}
+
+func (s CompensatedSum) computeContext(ctx context.Context, points []MassPoint) (MassPoint, error) {
+	if len(points) == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+
+	chunks := splitChunks(points, s.Workers)
+	partials := make([]WeightedSum, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []MassPoint) {
+			defer wg.Done()
+			var sum WeightedSum
+			for j, p := range chunk {
+				if j%checkEvery == 0 && ctx.Err() != nil {
+					return
+				}
+				sum.Add(p)
+			}
+			partials[i] = sum
+		}(i, chunk)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return MassPoint{}, err
+	}
+
+	var sum WeightedSum
+	for _, partial := range partials {
+		sum.Merge(partial)
+	}
+	return sum.Barycenter()
+}
+
+// exactPrec is enough bits of mantissa to hold any sum of float64 products exactly.
+// big.Float only uses as many words as the value actually needs.
+const exactPrec = 4400
+
+// ExactSum is the strategy for the Exact precision. Each worker sums its chunk of the
+// points exactly, the partial sums are added exactly, and the coordinates are only
+// rounded to float64 at the very end.
+type ExactSum struct {
+	// Workers is the number of goroutines to sum with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+}
+
+// exactSum holds the exact sums of m·x, m·y, m·z and m.
+type exactSum [4]*big.Float
+
+func newExactSum() exactSum {
+	var s exactSum
+	for i := range s {
+		s[i] = new(big.Float).SetPrec(exactPrec)
+	}
+	return s
+}
+
+// add adds a point to the sum. A float64 has a 53 bit mantissa, so 106 bits is enough
+// for the product of two of them to be exact.
+func (s exactSum) add(p MassPoint) {
+	var prod, mass big.Float
+	mass.SetFloat64(p.Mass)
+	for i, v := range [3]float64{p.X, p.Y, p.Z} {
+		prod.SetPrec(106).SetFloat64(v)
+		prod.Mul(&prod, &mass)
+		s[i].Add(s[i], &prod)
+	}
+	s[3].Add(s[3], &mass)
+}
+
+// Compute implements Strategy.
+func (s ExactSum) Compute(points []MassPoint) (MassPoint, error) {
+	return s.computeContext(context.Background(), points)
+}
+
+func (s ExactSum) computeContext(ctx context.Context, points []MassPoint) (MassPoint, error) {
+	if len(points) == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+	for _, p := range points {
+		if !isFinite(p) {
+			return MassPoint{}, ErrNotFinite
+		}
+	}
+
+	chunks := splitChunks(points, s.Workers)
+	partials := make([]exactSum, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []MassPoint) {
+			defer wg.Done()
+			sum := newExactSum()
+			for j, p := range chunk {
+				if j%checkEvery == 0 && ctx.Err() != nil {
+					return
+				}
+				sum.add(p)
+			}
+			partials[i] = sum
+		}(i, chunk)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return MassPoint{}, err
+	}
+
+	total := newExactSum()
+	for _, partial := range partials {
+		for i := range total {
+			total[i].Add(total[i], partial[i])
+		}
+	}
+
+	if total[3].Sign() == 0 {
+		return MassPoint{}, ErrZeroMass
+	}
+
+	// Dividing at float64 precision rounds each coordinate exactly once.
+	var result [4]float64
+	for i := 0; i < 3; i++ {
+		var q big.Float
+		q.SetPrec(53).Quo(total[i], total[3])
+		result[i], _ = q.Float64()
+	}
+	result[3], _ = total[3].Float64()
+	return MassPoint{result[0], result[1], result[2], result[3]}, nil
+}
+
+// isFinite reports whether every value in p is neither NaN nor infinite.
+func isFinite(p MassPoint) bool {
+	for _, v := range [4]float64{p.X, p.Y, p.Z, p.Mass} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// An ErrorBound estimates the largest absolute error in each coordinate of a computed
+// barycenter, and in its mass. The estimates are first order: they assume the error
+// in each operation is at most half a unit in the last place, and that the errors
+// don't get large enough to feed on each other.
+type ErrorBound struct {
+	X, Y, Z, Mass float64
+}
+
+// unitRoundoff is the largest relative error of a single float64 operation.
+const unitRoundoff = 1.0 / (1 << 53)
+
+// gamma is the classic bound on the relative error of n float64 operations in a row.
+func gamma(n int) float64 {
+	nu := float64(n) * unitRoundoff
+	return nu / (1 - nu)
+}
+
+// EstimateError estimates the error in result, the barycenter of points computed
+// at precision p.
+func EstimateError(points []MassPoint, result MassPoint, p Precision) ErrorBound {
+	var sum WeightedSum
+	for _, pt := range points {
+		sum.Add(pt)
+	}
+	return estimateBound(p, sum.Count, sum.Weighted(), [4]float64{sum.ax, sum.ay, sum.az, sum.am}, result)
+}
+
+// estimateBound works out an ErrorBound from the weighted sums of n points, the sums of
+// their absolute values, and the computed result.
+func estimateBound(p Precision, n int, weighted MassPoint, abs [4]float64, result MassPoint) ErrorBound {
+	if n == 0 || weighted.Mass == 0 {
+		return ErrorBound{}
+	}
+	mass := math.Abs(weighted.Mass)
+	coords := [3]float64{result.X, result.Y, result.Z}
+	var bound [4]float64
+
+	switch p {
+	case Exact:
+		// Everything is exact until the final rounding.
+		for i, c := range coords {
+			bound[i] = unitRoundoff * math.Abs(c)
+		}
+		bound[3] = unitRoundoff * mass
+
+	case Compensated:
+		// Each product is rounded once, and a compensated sum is good to about two units
+		// in the last place of the total, plus a second order term in the number of points.
+		second := 2 * float64(n) * float64(n) * unitRoundoff * unitRoundoff
+		w := [4]float64{weighted.X, weighted.Y, weighted.Z, weighted.Mass}
+		massErr := 2*unitRoundoff*mass + second*abs[3]
+		for i, c := range coords {
+			sumErr := unitRoundoff*abs[i] + 2*unitRoundoff*math.Abs(w[i]) + second*abs[i]
+			bound[i] = (sumErr+math.Abs(c)*massErr)/mass + unitRoundoff*math.Abs(c)
+		}
+		bound[3] = massErr
+
+	default:
+		// The pairwise tree is about log2(n) levels deep, and each level rounds an add,
+		// on top of the multiply into the weighted subspace and the divide out of it.
+		depth := 1
+		for 1<<uint(depth) < n {
+			depth++
+		}
+		massErr := gamma(depth) * abs[3]
+		for i, c := range coords {
+			sumErr := gamma(depth+1) * abs[i]
+			bound[i] = (sumErr+math.Abs(c)*massErr)/mass + unitRoundoff*math.Abs(c)
+		}
+		bound[3] = massErr
+	}
+	return ErrorBound{bound[0], bound[1], bound[2], bound[3]}
+}
@@ -0,0 +1,41 @@
+package barycenter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExactSumIgnoresWorkers(t *testing.T) {
+	for _, in := range strategyInputs {
+		want, wantErr := ExactSum{Workers: 1}.Compute(in.points)
+		for _, workers := range []int{2, 3, 8} {
+			got, err := ExactSum{Workers: workers}.Compute(in.points)
+			if err != wantErr || got != want {
+				t.Errorf("%s with %d workers: got %v, %v, want %v, %v", in.name, workers, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// The error bounds should hold against the exact result.
+func TestEstimateErrorBoundsTheError(t *testing.T) {
+	for _, in := range strategyInputs {
+		exact, err := ExactSum{}.Compute(in.points)
+		if err != nil {
+			continue
+		}
+		for _, p := range []Precision{Pairwise, Compensated} {
+			got, err := p.Strategy(4).Compute(in.points)
+			if err != nil {
+				t.Fatalf("%s at %v: %v", in.name, p, err)
+			}
+			bound := EstimateError(in.points, got, p)
+			errs := [4]float64{got.X - exact.X, got.Y - exact.Y, got.Z - exact.Z, got.Mass - exact.Mass}
+			for i, b := range [4]float64{bound.X, bound.Y, bound.Z, bound.Mass} {
+				if math.Abs(errs[i]) > b {
+					t.Errorf("%s at %v: error %g in %s is over the bound %g", in.name, p, errs[i], fieldNames[i], b)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package barycenter
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minRangeSize is the smallest byte range worth handing to its own worker.
+const minRangeSize = 64 << 10
+
+// A rangeResult is what a worker made of one byte range of the input,
+// other than the points themselves, which go into the range's sink.
+type rangeResult struct {
+	// rejects and err number their lines from the start of the range.
+	rejects Rejects
+	err     *ParseError
+	// lines counts the lines that start in the range, so later ranges can be renumbered.
+	lines int
+}
+
+// LoadFile loads the body file called name, which may be "-" for standard input.
+// Uncompressed text and binary files are mapped into memory, unless opts.Buffered says
+// not to, and split into byte ranges with LoadRanges; anything else, like a pipe, a
+// gzip-compressed file or a format read by opts.Decoder, is read with LoadConcurrent.
+// If opts.Name is empty, name is used in diagnostics.
+func LoadFile(name string, opts LoadOptions) ([]MassPoint, Rejects, error) {
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	in, err := openInput(name)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+	defer in.Close()
+
+	if in.size < 0 || customDecoder(opts) != nil {
+		return LoadConcurrent(in, opts)
+	}
+	return LoadRanges(in.ranges(opts), in.size, opts)
+}
+
+// LoadRanges loads size bytes of body records from r, in either format. Rather than
+// reading the input serially, it splits it into one byte range per worker, and each
+// worker reads and parses its own range. The partial results are then put back
+// together in order.
+//
+// Range boundaries rarely fall on a line break, so a line belongs to the range holding
+// its first byte: each worker skips the partial line it starts in, and finishes the
+// line it ends in. If r is a file mapped into memory, the workers parse its lines in
+// place; otherwise each reads its range through a buffer of its own.
+func LoadRanges(r io.ReaderAt, size int64, opts LoadOptions) ([]MassPoint, Rejects, error) {
+	parts := make([]*pointSink, rangeCount(size, opts.Workers))
+	sinks := make([]sink, len(parts))
+	for i := range parts {
+		parts[i] = &pointSink{}
+		sinks[i] = parts[i]
+	}
+	rejects, err := scanRanges(r, size, opts, sinks)
+	if err != nil {
+		return nil, Rejects{}, err
+	}
+
+	total := 0
+	for _, part := range parts {
+		total += len(part.points)
+	}
+	points := make([]MassPoint, 0, total)
+	for _, part := range parts {
+		points = append(points, part.points...)
+	}
+	return points, rejects, nil
+}
+
+// rangeCount decides how many byte ranges to split size bytes into.
+func rangeCount(size int64, workers int) int {
+	n := workers
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if max := int(size / minRangeSize); n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// scanRanges splits size bytes of r into one byte range per sink, and parses each range
+// into its own sink in its own goroutine.
+// If r holds the binary format, the records are split between the sinks instead.
+// Formats read by opts.Decoder can't be split, so they're read into the first sink.
+func scanRanges(r io.ReaderAt, size int64, opts LoadOptions, sinks []sink) (Rejects, error) {
+	opts.Progress.setTotal(size)
+	if dec := customDecoder(opts); dec != nil {
+		sr, opts := opts.track(io.NewSectionReader(r, 0, size))
+		return decodeInto(dec, sr, opts, sinks[0])
+	}
+	if isBinaryAt(r) {
+		return scanBinaryRanges(r, size, opts, sinks)
+	}
+
+	n := len(sinks)
+	results := make([]rangeResult, n)
+	errs := make([]error, n)
+	// failed is the index of the first range to hit a strict mode failure, so workers
+	// on later ranges know their results won't be needed.
+	failed := int64(n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		start := size * int64(i) / int64(n)
+		end := size * int64(i+1) / int64(n)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = loadRange(r, start, end, size, i, &failed, opts, sinks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// Now that every range knows how many lines it held, we can renumber the diagnostics.
+	offset := 0
+	var rejects Rejects
+	for i := range results {
+		if errs[i] != nil {
+			return Rejects{}, errs[i]
+		}
+		if perr := results[i].err; perr != nil {
+			perr.Line += offset
+			return Rejects{}, perr
+		}
+		results[i].rejects.shift(offset)
+		offset += results[i].lines
+		rejects.merge(results[i].rejects, opts.MaxExamples)
+	}
+	return rejects, nil
+}
+
+// loadRange parses the lines starting between start and end, the range numbered index, into s.
+func loadRange(r io.ReaderAt, start, end, size int64, index int, failed *int64, opts LoadOptions, s sink) (rangeResult, error) {
+	var res rangeResult
+	var readNext func() ([]byte, error)
+	if m, ok := r.(mappedFile); ok {
+		readNext = m.lines(start)
+	} else {
+		br := bufio.NewReaderSize(io.NewSectionReader(r, start, size-start), 64<<10)
+		var long []byte
+		readNext = func() ([]byte, error) { return readLine(br, &long) }
+	}
+	pos := start
+
+	// Unless the range starts right after a newline, the first line belongs to the previous range.
+	if start > 0 {
+		var prev [1]byte
+		if _, err := r.ReadAt(prev[:], start-1); err != nil {
+			return res, err
+		}
+		if prev[0] != '\n' {
+			line, err := readNext()
+			pos += int64(len(line))
+			if err == io.EOF {
+				return res, nil
+			} else if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	// Progress is counted up in batches, from where the range's own lines start.
+	counted, countedLines := pos, 0
+	defer func() { opts.Progress.add(pos-counted, int64(res.lines-countedLines)) }()
+
+	for pos < end {
+		// An earlier range has already failed, so there's no point carrying on.
+		if atomic.LoadInt64(failed) < int64(index) {
+			return res, nil
+		}
+		if res.lines%checkEvery == 0 {
+			if err := opts.stopped(); err != nil {
+				return res, err
+			}
+			opts.Progress.add(pos-counted, int64(res.lines-countedLines))
+			counted, countedLines = pos, res.lines
+		}
+
+		line, err := readNext()
+		if len(line) > 0 {
+			pos += int64(len(line))
+			res.lines++
+			b, ok, perr := parseBodyLine(line, res.lines, opts)
+			if perr != nil {
+				if res.err = res.rejects.note(perr, opts); res.err != nil {
+					markFailed(failed, index)
+					return res, nil
+				}
+			}
+			if ok {
+				s.addBody(b)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// markFailed lowers failed to index, if index is lower.
+func markFailed(failed *int64, index int) {
+	for {
+		cur := atomic.LoadInt64(failed)
+		if int64(index) >= cur || atomic.CompareAndSwapInt64(failed, cur, int64(index)) {
+			return
+		}
+	}
+}
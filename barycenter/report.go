@@ -0,0 +1,478 @@
+package barycenter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// A Report is everything the barycenter commands know about a run, ready to be
+// printed for people or handed to other programs as JSON or CSV.
+type Report struct {
+	// Barycenter is the virtual body at the barycenter, carrying the system's mass.
+	Barycenter MassPoint
+	// Bodies is the number of bodies loaded, and Rejected the number of malformed records skipped.
+	// Flagged is the number of bodies loaded in spite of invalid values.
+	Bodies, Rejected, Flagged int
+	// Load and Compute are how long loading and computing the barycenter took.
+	// When streaming, the two happen together, and it's all counted as loading.
+	Load, Compute time.Duration
+	// Workers is the number of goroutines used.
+	Workers int
+	// Strategy names how the barycenter was computed, like linear, chunked or stream.
+	Strategy string
+	// ErrorBound is the estimated error, if it was asked for.
+	ErrorBound *ErrorBound
+	// Velocity is the velocity of the barycenter, if it was asked for.
+	Velocity *Vector
+	// Moments is the spread of the mass around the barycenter, if it was asked for.
+	Moments *Moments
+}
+
+// Output is a way of printing a Report.
+type Output int
+
+const (
+	// TextOutput is the prose the commands have always printed.
+	TextOutput Output = iota
+	// JSONOutput is a single JSON object.
+	JSONOutput
+	// CSVOutput is a header row and a single row of values.
+	CSVOutput
+)
+
+var outputNames = [...]string{"text", "json", "csv"}
+
+func (o Output) String() string {
+	if o < 0 || int(o) >= len(outputNames) {
+		return fmt.Sprintf("Output(%d)", int(o))
+	}
+	return outputNames[o]
+}
+
+// ParseOutput looks up an output by name: text, json or csv.
+func ParseOutput(name string) (Output, error) {
+	for i, n := range outputNames {
+		if n == name {
+			return Output(i), nil
+		}
+	}
+	return 0, fmt.Errorf("barycenter: unknown output %q", name)
+}
+
+// jsonReport is the layout of a Report in JSON.
+type jsonReport struct {
+	Barycenter struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+		Z float64 `json:"z"`
+	} `json:"barycenter"`
+	Mass           float64      `json:"mass"`
+	Bodies         int          `json:"bodies"`
+	Rejected       int          `json:"rejected"`
+	Flagged        int          `json:"flagged"`
+	LoadSeconds    float64      `json:"load_seconds"`
+	ComputeSeconds float64      `json:"compute_seconds"`
+	Workers        int          `json:"workers"`
+	Strategy       string       `json:"strategy"`
+	ErrorBound     *jsonBound   `json:"error_bound,omitempty"`
+	Velocity       *jsonVec     `json:"velocity,omitempty"`
+	Moments        *jsonMoments `json:"moments,omitempty"`
+}
+
+type jsonMoments struct {
+	Variance         jsonVec       `json:"variance"`
+	Covariance       [3][3]float64 `json:"covariance"`
+	Inertia          [3][3]float64 `json:"inertia"`
+	PrincipalMoments [3]float64    `json:"principal_moments"`
+	PrincipalAxes    [3]jsonVec    `json:"principal_axes"`
+	RadiusOfGyration float64       `json:"radius_of_gyration"`
+}
+
+type jsonVec struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+type jsonBound struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Z    float64 `json:"z"`
+	Mass float64 `json:"mass"`
+}
+
+// csvHeader names the columns written by CSVOutput.
+var csvHeader = []string{
+	"x", "y", "z", "mass", "bodies", "rejected", "flagged", "load_seconds", "compute_seconds",
+	"workers", "strategy", "error_x", "error_y", "error_z", "error_mass", "vx", "vy", "vz",
+	"var_x", "var_y", "var_z", "radius_of_gyration", "ixx", "iyy", "izz", "ixy", "ixz", "iyz",
+	"i1", "i2", "i3", "axis1_x", "axis1_y", "axis1_z", "axis2_x", "axis2_y", "axis2_z",
+	"axis3_x", "axis3_y", "axis3_z",
+}
+
+// Write prints the report to w in the given output.
+func (r Report) Write(w io.Writer, o Output) error {
+	switch o {
+	case JSONOutput:
+		return r.writeJSON(w)
+	case CSVOutput:
+		return r.writeCSV(w)
+	}
+	return r.writeText(w)
+}
+
+func (r Report) writeText(w io.Writer) error {
+	// Streams, and distributed runs, compute as they load.
+	streamed := r.Strategy == "stream" || r.Strategy == "distributed"
+	verb := "Loaded"
+	if streamed {
+		verb = "Streamed"
+	}
+	fmt.Fprintf(w, "%s %d values from file in %s.\n", verb, r.Bodies, r.Load)
+	fmt.Fprintf(w, "System barycenter is at (%f, %f, %f) and the system's mass is %f.\n",
+		r.Barycenter.X,
+		r.Barycenter.Y,
+		r.Barycenter.Z,
+		r.Barycenter.Mass)
+	if !streamed {
+		fmt.Fprintf(w, "Calculation took %s.\n", r.Compute)
+	}
+	if b := r.ErrorBound; b != nil {
+		fmt.Fprintf(w, "Estimated error is at most (%g, %g, %g), and %g in the mass.\n",
+			b.X, b.Y, b.Z, b.Mass)
+	}
+	if v := r.Velocity; v != nil {
+		fmt.Fprintf(w, "The barycenter is moving at (%f, %f, %f).\n", v.X, v.Y, v.Z)
+	}
+	if m := r.Moments; m != nil {
+		fmt.Fprintf(w, "Variance along each axis is (%g, %g, %g), and the radius of gyration is %g.\n",
+			m.Variance.X, m.Variance.Y, m.Variance.Z, m.RadiusOfGyration)
+		fmt.Fprintln(w, "Moment of inertia tensor about the barycenter:")
+		for _, row := range m.Inertia {
+			fmt.Fprintf(w, "  %14g %14g %14g\n", row[0], row[1], row[2])
+		}
+		for i, a := range m.PrincipalAxes {
+			fmt.Fprintf(w, "Principal moment %g about axis (%f, %f, %f).\n",
+				m.PrincipalMoments[i], a.X, a.Y, a.Z)
+		}
+	}
+	return nil
+}
+
+func (r Report) writeJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.toJSON())
+}
+
+// toJSON lays the report out for JSON.
+func (r Report) toJSON() jsonReport {
+	var out jsonReport
+	out.Barycenter.X = r.Barycenter.X
+	out.Barycenter.Y = r.Barycenter.Y
+	out.Barycenter.Z = r.Barycenter.Z
+	out.Mass = r.Barycenter.Mass
+	out.Bodies = r.Bodies
+	out.Rejected = r.Rejected
+	out.Flagged = r.Flagged
+	out.LoadSeconds = r.Load.Seconds()
+	out.ComputeSeconds = r.Compute.Seconds()
+	out.Workers = r.Workers
+	out.Strategy = r.Strategy
+	if b := r.ErrorBound; b != nil {
+		out.ErrorBound = &jsonBound{b.X, b.Y, b.Z, b.Mass}
+	}
+	if v := r.Velocity; v != nil {
+		out.Velocity = &jsonVec{v.X, v.Y, v.Z}
+	}
+	if m := r.Moments; m != nil {
+		out.Moments = &jsonMoments{
+			Variance:         jsonVec{m.Variance.X, m.Variance.Y, m.Variance.Z},
+			Covariance:       m.Covariance,
+			Inertia:          m.Inertia,
+			PrincipalMoments: m.PrincipalMoments,
+			RadiusOfGyration: m.RadiusOfGyration,
+		}
+		for i, a := range m.PrincipalAxes {
+			out.Moments.PrincipalAxes[i] = jsonVec{a.X, a.Y, a.Z}
+		}
+	}
+	return out
+}
+
+func (r Report) writeCSV(w io.Writer) error {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	// The values are looked up by column name, so the row always lines up with csvHeader,
+	// and the columns of anything that wasn't asked for are left empty.
+	values := map[string]string{
+		"x": f(r.Barycenter.X), "y": f(r.Barycenter.Y), "z": f(r.Barycenter.Z), "mass": f(r.Barycenter.Mass),
+		"bodies": strconv.Itoa(r.Bodies), "rejected": strconv.Itoa(r.Rejected), "flagged": strconv.Itoa(r.Flagged),
+		"load_seconds": f(r.Load.Seconds()), "compute_seconds": f(r.Compute.Seconds()),
+		"workers": strconv.Itoa(r.Workers), "strategy": r.Strategy,
+	}
+	if b := r.ErrorBound; b != nil {
+		values["error_x"], values["error_y"], values["error_z"], values["error_mass"] = f(b.X), f(b.Y), f(b.Z), f(b.Mass)
+	}
+	if v := r.Velocity; v != nil {
+		values["vx"], values["vy"], values["vz"] = f(v.X), f(v.Y), f(v.Z)
+	}
+	if m := r.Moments; m != nil {
+		values["var_x"], values["var_y"], values["var_z"] = f(m.Variance.X), f(m.Variance.Y), f(m.Variance.Z)
+		values["radius_of_gyration"] = f(m.RadiusOfGyration)
+		axes := "xyz"
+		for i := 0; i < 3; i++ {
+			for j := i; j < 3; j++ {
+				values[fmt.Sprintf("i%c%c", axes[i], axes[j])] = f(m.Inertia[i][j])
+			}
+			values[fmt.Sprintf("i%d", i+1)] = f(m.PrincipalMoments[i])
+			a := m.PrincipalAxes[i]
+			for j, v := range []float64{a.X, a.Y, a.Z} {
+				values[fmt.Sprintf("axis%d_%c", i+1, axes[j])] = f(v)
+			}
+		}
+	}
+	row := make([]string, len(csvHeader))
+	for i, name := range csvHeader {
+		row[i] = values[name]
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	cw.Write(row)
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGroups prints a table of groups to w in the given output: lined-up columns for
+// text, an array of objects for JSON, and a header row and a row per group for CSV.
+// The label and cell columns are only there if the grouping uses them.
+func WriteGroups(w io.Writer, groups []Group, g Grouping, o Output) error {
+	header, rows := groupTable(groups, g)
+	switch o {
+	case JSONOutput:
+		return writeGroupsJSON(w, groups, g)
+	case CSVOutput:
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+	// The tabwriter writes a cell at a time, so it gets a buffer in front of w.
+	bw := bufio.NewWriter(w)
+	tw := tabwriter.NewWriter(bw, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for _, row := range append([][]string{header}, rows...) {
+		for _, v := range row {
+			io.WriteString(tw, v)
+			io.WriteString(tw, "\t")
+		}
+		io.WriteString(tw, "\n")
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// groupTable lays out groups as rows of strings under a header, for text and CSV.
+func groupTable(groups []Group, g Grouping) ([]string, [][]string) {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	var header []string
+	if g.ByLabel {
+		header = append(header, "label")
+	}
+	if g.gridded() {
+		header = append(header, "cell_x", "cell_y", "cell_z")
+	}
+	header = append(header, "bodies", "x", "y", "z", "mass")
+
+	rows := make([][]string, len(groups))
+	for i, gr := range groups {
+		var row []string
+		if g.ByLabel {
+			row = append(row, gr.Label)
+		}
+		if g.gridded() {
+			for _, c := range gr.Cell {
+				row = append(row, strconv.FormatInt(c, 10))
+			}
+		}
+		b := gr.Barycenter
+		rows[i] = append(row, strconv.Itoa(gr.Bodies), f(b.X), f(b.Y), f(b.Z), f(b.Mass))
+	}
+	return header, rows
+}
+
+// jsonGroup is the layout of a Group in JSON.
+type jsonGroup struct {
+	Label      *string   `json:"label,omitempty"`
+	Cell       *[3]int64 `json:"cell,omitempty"`
+	Bodies     int       `json:"bodies"`
+	Barycenter jsonVec   `json:"barycenter"`
+	Mass       float64   `json:"mass"`
+}
+
+func writeGroupsJSON(w io.Writer, groups []Group, g Grouping) error {
+	out := make([]jsonGroup, len(groups))
+	for i := range groups {
+		gr := &groups[i]
+		if g.ByLabel {
+			out[i].Label = &gr.Label
+		}
+		if g.gridded() {
+			out[i].Cell = &gr.Cell
+		}
+		out[i].Bodies = gr.Bodies
+		out[i].Barycenter = jsonVec{gr.Barycenter.X, gr.Barycenter.Y, gr.Barycenter.Z}
+		out[i].Mass = gr.Barycenter.Mass
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// WriteFiles prints the barycenter of each of several files, and then the combined report
+// for all of them, to w in the given output. Text gets a table of files followed by the
+// report as usual. JSON gets an object with the files as an array, and the report.
+// CSV gets a row per file, and a last row, with no file name, for the whole system.
+func WriteFiles(w io.Writer, files []FileResult, total Report, o Output) error {
+	switch o {
+	case JSONOutput:
+		return writeFilesJSON(w, files, total)
+	case CSVOutput:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"file", "bodies", "rejected", "flagged", "x", "y", "z", "mass"})
+		for _, f := range files {
+			cw.Write(fileRow(f.Name, f.Bodies, f.Rejected, f.Flagged, f.Barycenter))
+		}
+		cw.Write(fileRow("", total.Bodies, total.Rejected, total.Flagged, total.Barycenter))
+		cw.Flush()
+		return cw.Error()
+	}
+	bw := bufio.NewWriter(w)
+	tw := tabwriter.NewWriter(bw, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\tbodies\trejected\tx\ty\tz\tmass\t")
+	for _, f := range files {
+		b := f.Barycenter
+		if f.Bodies == 0 {
+			fmt.Fprintf(tw, "%s\t0\t%d\t\t\t\t\t\n", f.Name, f.Rejected)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%f\t%f\t%f\t%f\t\n", f.Name, f.Bodies, f.Rejected, b.X, b.Y, b.Z, b.Mass)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nAcross all %d files:\n", len(files))
+	return total.writeText(w)
+}
+
+// fileRow lays out one file's result for CSV.
+func fileRow(name string, bodies, rejected, flagged int, b MassPoint) []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	row := []string{name, strconv.Itoa(bodies), strconv.Itoa(rejected), strconv.Itoa(flagged), "", "", "", ""}
+	if bodies > 0 {
+		row[4], row[5], row[6], row[7] = f(b.X), f(b.Y), f(b.Z), f(b.Mass)
+	}
+	return row
+}
+
+// jsonFile is the layout of a FileResult in JSON.
+type jsonFile struct {
+	File       string   `json:"file"`
+	Bodies     int      `json:"bodies"`
+	Rejected   int      `json:"rejected"`
+	Flagged    int      `json:"flagged"`
+	Barycenter *jsonVec `json:"barycenter,omitempty"`
+	Mass       float64  `json:"mass"`
+}
+
+func writeFilesJSON(w io.Writer, files []FileResult, total Report) error {
+	out := struct {
+		Files []jsonFile `json:"files"`
+		Total jsonReport `json:"total"`
+	}{make([]jsonFile, len(files)), total.toJSON()}
+	for i, f := range files {
+		out.Files[i] = jsonFile{File: f.Name, Bodies: f.Bodies, Rejected: f.Rejected, Flagged: f.Flagged,
+			Mass: f.Barycenter.Mass}
+		if f.Bodies > 0 {
+			out.Files[i].Barycenter = &jsonVec{f.Barycenter.X, f.Barycenter.Y, f.Barycenter.Z}
+		}
+	}
+	return json.NewEncoder(w).Encode(out)
+}
+
+// A ReportN is a Report for a system in any number of dimensions.
+type ReportN struct {
+	// Barycenter is the virtual point at the barycenter, carrying the system's mass.
+	Barycenter Centroid
+	// Bodies, Rejected and Flagged count the bodies as they do in a Report.
+	Bodies, Rejected, Flagged int
+	// Load and Compute are how long loading and computing the barycenter took.
+	Load, Compute time.Duration
+	// Workers is the number of goroutines used.
+	Workers int
+	// Strategy names how the barycenter was computed, like linear or chunked.
+	Strategy string
+}
+
+// jsonReportN is the layout of a ReportN in JSON. The barycenter is an array, since its
+// coordinates have no names.
+type jsonReportN struct {
+	Dimensions     int       `json:"dimensions"`
+	Barycenter     []float64 `json:"barycenter"`
+	Mass           float64   `json:"mass"`
+	Bodies         int       `json:"bodies"`
+	Rejected       int       `json:"rejected"`
+	Flagged        int       `json:"flagged"`
+	LoadSeconds    float64   `json:"load_seconds"`
+	ComputeSeconds float64   `json:"compute_seconds"`
+	Workers        int       `json:"workers"`
+	Strategy       string    `json:"strategy"`
+}
+
+// Write prints the report to w in the given output. The CSV header names the coordinates
+// x1, x2 and so on, so it has as many columns as the system has dimensions.
+func (r ReportN) Write(w io.Writer, o Output) error {
+	c := r.Barycenter
+	switch o {
+	case JSONOutput:
+		return json.NewEncoder(w).Encode(jsonReportN{
+			Dimensions: len(c.Coords), Barycenter: c.Coords, Mass: c.Mass,
+			Bodies: r.Bodies, Rejected: r.Rejected, Flagged: r.Flagged,
+			LoadSeconds: r.Load.Seconds(), ComputeSeconds: r.Compute.Seconds(),
+			Workers: r.Workers, Strategy: r.Strategy,
+		})
+	case CSVOutput:
+		f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+		var header, row []string
+		for i, v := range c.Coords {
+			header = append(header, fmt.Sprintf("x%d", i+1))
+			row = append(row, f(v))
+		}
+		header = append(header, "mass", "bodies", "rejected", "flagged", "load_seconds",
+			"compute_seconds", "workers", "strategy")
+		row = append(row, f(c.Mass), strconv.Itoa(r.Bodies), strconv.Itoa(r.Rejected),
+			strconv.Itoa(r.Flagged), f(r.Load.Seconds()), f(r.Compute.Seconds()),
+			strconv.Itoa(r.Workers), r.Strategy)
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.Write(row)
+		cw.Flush()
+		return cw.Error()
+	}
+	fmt.Fprintf(w, "Loaded %d values in %d dimensions from file in %s.\n", r.Bodies, len(c.Coords), r.Load)
+	fmt.Fprint(w, "System barycenter is at (")
+	for i, v := range c.Coords {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprintf(w, "%f", v)
+	}
+	fmt.Fprintf(w, ") and the system's mass is %f.\n", c.Mass)
+	_, err := fmt.Fprintf(w, "Calculation took %s.\n", r.Compute)
+	return err
+}
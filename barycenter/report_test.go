@@ -0,0 +1,212 @@
+package barycenter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fullReport has every optional part filled in, with no two values the same, so a value
+// written in the wrong place can't go unnoticed.
+func fullReport() Report {
+	return Report{
+		Barycenter: MassPoint{1.5, -2.25, 3.125, 1e6},
+		Bodies:     1000, Rejected: 7, Flagged: 3,
+		Load: 1500 * time.Millisecond, Compute: 250 * time.Millisecond,
+		Workers: 4, Strategy: "chunked",
+		ErrorBound: &ErrorBound{1e-12, 2e-12, 3e-12, 4e-9},
+		Velocity:   &Vector{0.5, 0.25, -0.75},
+		Moments: &Moments{
+			Variance:         Vector{11, 12, 13},
+			Inertia:          [3][3]float64{{21, 22, 23}, {22, 24, 25}, {23, 25, 26}},
+			PrincipalMoments: [3]float64{31, 32, 33},
+			PrincipalAxes:    [3]Vector{{41, 42, 43}, {44, 45, 46}, {47, 48, 49}},
+			RadiusOfGyration: 51,
+		},
+	}
+}
+
+// reportFields are the values of fullReport, by CSV column name.
+var reportFields = map[string]float64{
+	"x": 1.5, "y": -2.25, "z": 3.125, "mass": 1e6,
+	"bodies": 1000, "rejected": 7, "flagged": 3,
+	"load_seconds": 1.5, "compute_seconds": 0.25, "workers": 4,
+	"error_x": 1e-12, "error_y": 2e-12, "error_z": 3e-12, "error_mass": 4e-9,
+	"vx": 0.5, "vy": 0.25, "vz": -0.75,
+	"var_x": 11, "var_y": 12, "var_z": 13, "radius_of_gyration": 51,
+	"ixx": 21, "ixy": 22, "ixz": 23, "iyy": 24, "iyz": 25, "izz": 26,
+	"i1": 31, "i2": 32, "i3": 33,
+	"axis1_x": 41, "axis1_y": 42, "axis1_z": 43,
+	"axis2_x": 44, "axis2_y": 45, "axis2_z": 46,
+	"axis3_x": 47, "axis3_y": 48, "axis3_z": 49,
+}
+
+// requiredColumns are the CSV columns that always have a value; the rest are empty unless
+// their part of the report was asked for.
+var requiredColumns = map[string]bool{
+	"x": true, "y": true, "z": true, "mass": true, "bodies": true, "rejected": true,
+	"flagged": true, "load_seconds": true, "compute_seconds": true, "workers": true,
+}
+
+// readReportCSV parses a report written as CSV into its values, by column name.
+func readReportCSV(t *testing.T, r Report) map[string]string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.Write(&buf, CSVOutput); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[0]) != len(records[1]) {
+		t.Fatalf("got %d records, want a header and a row of the same length: %q", len(records), records)
+	}
+	values := make(map[string]string)
+	for i, name := range records[0] {
+		if _, dup := values[name]; dup {
+			t.Fatalf("column %q appears twice", name)
+		}
+		values[name] = records[1][i]
+	}
+	return values
+}
+
+func TestReportCSV(t *testing.T) {
+	values := readReportCSV(t, fullReport())
+	if len(values) != len(reportFields)+1 {
+		t.Errorf("got %d columns, want %d", len(values), len(reportFields)+1)
+	}
+	if values["strategy"] != "chunked" {
+		t.Errorf("strategy: got %q, want chunked", values["strategy"])
+	}
+	for name, want := range reportFields {
+		got, err := strconv.ParseFloat(values[name], 64)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, want %g", name, values[name], want)
+		}
+	}
+
+	// Without the optional parts, their columns are still there, but empty.
+	r := fullReport()
+	r.ErrorBound, r.Velocity, r.Moments = nil, nil, nil
+	values = readReportCSV(t, r)
+	for name := range reportFields {
+		if v, ok := values[name]; !ok || (v != "") != requiredColumns[name] {
+			t.Errorf("without the optional parts, %s is %q", name, v)
+		}
+	}
+}
+
+// readReportJSON parses a report written as JSON into a generic value, so the test sees
+// the names a pipeline would, rather than going back through jsonReport.
+func readReportJSON(t *testing.T, r Report) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.Write(&buf, JSONOutput); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// jsonField follows a path of object keys and array indexes into a decoded JSON value.
+func jsonField(v interface{}, path ...interface{}) (interface{}, bool) {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[p]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || p >= len(a) {
+				return nil, false
+			}
+			v = a[p]
+		}
+	}
+	return v, true
+}
+
+func TestReportJSON(t *testing.T) {
+	out := readReportJSON(t, fullReport())
+	m := "moments"
+	fields := []struct {
+		path []interface{}
+		want interface{}
+	}{
+		{[]interface{}{"barycenter", "x"}, 1.5},
+		{[]interface{}{"barycenter", "y"}, -2.25},
+		{[]interface{}{"barycenter", "z"}, 3.125},
+		{[]interface{}{"mass"}, 1e6},
+		{[]interface{}{"bodies"}, 1000.0},
+		{[]interface{}{"rejected"}, 7.0},
+		{[]interface{}{"flagged"}, 3.0},
+		{[]interface{}{"load_seconds"}, 1.5},
+		{[]interface{}{"compute_seconds"}, 0.25},
+		{[]interface{}{"workers"}, 4.0},
+		{[]interface{}{"strategy"}, "chunked"},
+		{[]interface{}{"error_bound", "x"}, 1e-12},
+		{[]interface{}{"error_bound", "y"}, 2e-12},
+		{[]interface{}{"error_bound", "z"}, 3e-12},
+		{[]interface{}{"error_bound", "mass"}, 4e-9},
+		{[]interface{}{"velocity", "x"}, 0.5},
+		{[]interface{}{"velocity", "y"}, 0.25},
+		{[]interface{}{"velocity", "z"}, -0.75},
+		{[]interface{}{m, "variance", "x"}, 11.0},
+		{[]interface{}{m, "variance", "y"}, 12.0},
+		{[]interface{}{m, "variance", "z"}, 13.0},
+		{[]interface{}{m, "radius_of_gyration"}, 51.0},
+		{[]interface{}{m, "inertia", 0, 0}, 21.0},
+		{[]interface{}{m, "inertia", 1, 2}, 25.0},
+		{[]interface{}{m, "inertia", 2, 1}, 25.0},
+		{[]interface{}{m, "inertia", 2, 2}, 26.0},
+		{[]interface{}{m, "principal_moments", 0}, 31.0},
+		{[]interface{}{m, "principal_moments", 2}, 33.0},
+		{[]interface{}{m, "principal_axes", 0, "x"}, 41.0},
+		{[]interface{}{m, "principal_axes", 1, "y"}, 45.0},
+		{[]interface{}{m, "principal_axes", 2, "z"}, 49.0},
+	}
+	for _, f := range fields {
+		got, ok := jsonField(out, f.path...)
+		if !ok || got != f.want {
+			t.Errorf("%v: got %v, want %v", f.path, got, f.want)
+		}
+	}
+
+	// Without the optional parts, their keys are left out altogether.
+	r := fullReport()
+	r.ErrorBound, r.Velocity, r.Moments = nil, nil, nil
+	out = readReportJSON(t, r)
+	for _, key := range []string{"error_bound", "velocity", "moments"} {
+		if v, ok := out[key]; ok {
+			t.Errorf("without the optional parts, %s is %v", key, v)
+		}
+	}
+	if got, _ := jsonField(out, "workers"); got != 4.0 {
+		t.Errorf("without the optional parts, workers is %v", got)
+	}
+}
+
+// Every CSV column should be one that TestReportCSV checks.
+func TestReportCSVHeader(t *testing.T) {
+	for _, name := range csvHeader {
+		if _, ok := reportFields[name]; !ok && name != "strategy" {
+			t.Errorf("column %s isn't checked by TestReportCSV", name)
+		}
+	}
+	if len(csvHeader) != len(reportFields)+1 {
+		t.Errorf("csvHeader has %d columns, want %d", len(csvHeader), len(reportFields)+1)
+	}
+}
@@ -0,0 +1,214 @@
+package barycenter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// ServiceOptions sets out the limits of a barycenter service.
+type ServiceOptions struct {
+	// Workers is the number of goroutines each request is parsed with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+	// MaxBytes is the largest upload accepted, in bytes. If it's zero or less, there's no limit.
+	MaxBytes int64
+	// Timeout is how long a request has to finish its upload. If it's zero or less,
+	// there's no limit.
+	Timeout time.Duration
+	// MaxConcurrent is how many requests are worked on at once. Requests beyond that are
+	// turned away with 503 Service Unavailable. If it's zero or less, there's no limit.
+	MaxConcurrent int
+	// MaxExamples is how many rejected lines are described in each reply, unless the
+	// request asks for a different number.
+	MaxExamples int
+}
+
+// MaxRequestExamples is the most rejected lines a request can ask to have described.
+// Every one is kept in memory and sent back, so a request for more is turned away.
+const MaxRequestExamples = 1000
+
+// serviceReply is the JSON a service replies with: the same report the commands print
+// with -output json, and the rejected lines.
+type serviceReply struct {
+	jsonReport
+	Examples        []string `json:"examples,omitempty"`
+	FlaggedExamples []string `json:"flagged_examples,omitempty"`
+}
+
+// serviceError is the JSON a service replies with when it can't find a barycenter.
+type serviceError struct {
+	Error  string `json:"error"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// NewService returns an HTTP handler that works out barycenters of uploaded body files.
+//
+//	POST /barycenter?strict=...&invalid=...&examples=...&errorbound=...
+//	     The body is a body file, in the text or binary format. It's folded into running
+//	     sums as it arrives, just like concurrentBarycenter -stream, so it's never held in
+//	     memory. The reply is a JSON report.
+//	GET  /health
+//	     200 if the service is up.
+//
+// Failures are replied to with JSON too, with an error message: 400 for bad parameters,
+// 405 for the wrong method, 413 for uploads over MaxBytes, 408 for uploads that take
+// longer than Timeout, 422 for input with no barycenter or, in strict mode, a malformed
+// line, and 503 when there are already MaxConcurrent requests under way.
+func NewService(o ServiceOptions) http.Handler {
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	var slots chan struct{}
+	if o.MaxConcurrent > 0 {
+		slots = make(chan struct{}, o.MaxConcurrent)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			replyError(w, http.StatusMethodNotAllowed, serviceError{Error: "health checks must be GETs"})
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/barycenter", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			replyError(w, http.StatusMethodNotAllowed, serviceError{Error: "body files must be POSTed"})
+			return
+		}
+		// Rather than queueing up requests we can't get to, we tell the client to come back later.
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				replyError(w, http.StatusServiceUnavailable, serviceError{Error: "too many requests under way"})
+				return
+			}
+		}
+		serveBarycenter(w, r, o)
+	})
+	return mux
+}
+
+// serveBarycenter streams one upload into running sums, and replies with the report.
+func serveBarycenter(w http.ResponseWriter, r *http.Request, o ServiceOptions) {
+	opts, errorBound, err := serviceOptions(r, o)
+	if err != nil {
+		replyError(w, http.StatusBadRequest, serviceError{Error: err.Error()})
+		return
+	}
+
+	// The deadline is on the connection, so a slow upload fails in the middle of a read,
+	// rather than being left to run after we've given up on it.
+	if o.Timeout > 0 {
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Now().Add(o.Timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			replyError(w, http.StatusInternalServerError, serviceError{Error: err.Error()})
+			return
+		}
+	}
+	var body io.Reader = r.Body
+	if o.MaxBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, o.MaxBytes)
+	}
+
+	start := time.Now()
+	sum, rejects, err := StreamConcurrent(body, opts)
+	var tooBig *http.MaxBytesError
+	var perr *ParseError
+	switch {
+	case errors.As(err, &tooBig):
+		replyError(w, http.StatusRequestEntityTooLarge,
+			serviceError{Error: fmt.Sprintf("upload is over the limit of %d bytes", tooBig.Limit)})
+		return
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		replyError(w, http.StatusRequestTimeout,
+			serviceError{Error: fmt.Sprintf("upload took longer than %s", o.Timeout)})
+		return
+	case errors.As(err, &perr):
+		replyError(w, http.StatusUnprocessableEntity,
+			serviceError{Error: perr.Err.Error(), Line: perr.Line, Column: perr.Column})
+		return
+	case err != nil:
+		replyError(w, http.StatusBadRequest, serviceError{Error: err.Error()})
+		return
+	}
+
+	report := Report{
+		Bodies:   sum.Count,
+		Rejected: rejects.Count,
+		Flagged:  rejects.Flagged,
+		Load:     time.Since(start),
+		Workers:  opts.Workers,
+		Strategy: "stream",
+	}
+	if report.Barycenter, err = sum.Barycenter(); err != nil {
+		replyError(w, http.StatusUnprocessableEntity, serviceError{Error: err.Error()})
+		return
+	}
+	if errorBound {
+		bound := sum.ErrorBound()
+		report.ErrorBound = &bound
+	}
+
+	reply := serviceReply{jsonReport: report.toJSON()}
+	for _, e := range rejects.Examples {
+		reply.Examples = append(reply.Examples, e.Error())
+	}
+	for _, e := range rejects.FlaggedExamples {
+		reply.FlaggedExamples = append(reply.FlaggedExamples, e.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// serviceOptions works out the load options for a request from its parameters.
+func serviceOptions(r *http.Request, o ServiceOptions) (LoadOptions, bool, error) {
+	q := r.URL.Query()
+	// If the client hangs up, the request's context is cancelled, and the workers stop.
+	opts := LoadOptions{Name: "upload", Workers: o.Workers, MaxExamples: o.MaxExamples, Context: r.Context()}
+	var err error
+	if s := q.Get("strict"); s != "" {
+		if opts.Strict, err = strconv.ParseBool(s); err != nil {
+			return LoadOptions{}, false, fmt.Errorf("bad strict parameter %q", s)
+		}
+	}
+	if s := q.Get("examples"); s != "" {
+		if opts.MaxExamples, err = strconv.Atoi(s); err != nil || opts.MaxExamples < 0 {
+			return LoadOptions{}, false, fmt.Errorf("bad examples parameter %q", s)
+		}
+		if opts.MaxExamples > MaxRequestExamples {
+			return LoadOptions{}, false, fmt.Errorf("examples parameter %d is over the limit of %d", opts.MaxExamples, MaxRequestExamples)
+		}
+	}
+	if s := q.Get("invalid"); s != "" {
+		if opts.Validation, err = ParseValidation(s); err != nil {
+			return LoadOptions{}, false, err
+		}
+	}
+	errorBound := false
+	if s := q.Get("errorbound"); s != "" {
+		if errorBound, err = strconv.ParseBool(s); err != nil {
+			return LoadOptions{}, false, fmt.Errorf("bad errorbound parameter %q", s)
+		}
+	}
+	return opts, errorBound, nil
+}
+
+func replyError(w http.ResponseWriter, status int, e serviceError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
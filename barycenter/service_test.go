@@ -0,0 +1,148 @@
+package barycenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServiceExamplesLimit(t *testing.T) {
+	service := NewService(ServiceOptions{Workers: 2, MaxExamples: 5})
+	for _, c := range []struct {
+		examples string
+		want     int
+	}{
+		{"0", http.StatusOK},
+		{fmt.Sprint(MaxRequestExamples), http.StatusOK},
+		{fmt.Sprint(MaxRequestExamples + 1), http.StatusBadRequest},
+		{"9223372036854775807", http.StatusBadRequest},
+		{"-1", http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/barycenter?examples="+c.examples,
+			strings.NewReader("1:2:3:4\n5:6:7:8\n"))
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("examples=%s: got status %d, want %d: %s", c.examples, w.Code, c.want, w.Body)
+		}
+	}
+}
+
+// serve sends the service a request, and returns the reply.
+func serve(service http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest(method, target, body))
+	return w
+}
+
+func TestServiceMethods(t *testing.T) {
+	service := NewService(ServiceOptions{})
+	for _, tc := range []struct {
+		method, path string
+		want         int
+		allow        string
+	}{
+		{http.MethodGet, "/health", http.StatusOK, ""},
+		{http.MethodHead, "/health", http.StatusOK, ""},
+		{http.MethodPost, "/health", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/health", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/barycenter", http.StatusMethodNotAllowed, "POST"},
+	} {
+		w := serve(service, tc.method, tc.path, nil)
+		if w.Code != tc.want || w.Header().Get("Allow") != tc.allow {
+			t.Errorf("%s %s: got status %d, Allow %q, want %d, %q",
+				tc.method, tc.path, w.Code, w.Header().Get("Allow"), tc.want, tc.allow)
+		}
+	}
+}
+
+func TestServiceMaxBytes(t *testing.T) {
+	service := NewService(ServiceOptions{MaxBytes: 100})
+	body := strings.Repeat("1:2:3:4\n", 10)
+	if w := serve(service, http.MethodPost, "/barycenter", strings.NewReader(body)); w.Code != http.StatusOK {
+		t.Errorf("%d bytes: got status %d, want 200: %s", len(body), w.Code, w.Body)
+	}
+	body = strings.Repeat("1:2:3:4\n", 100)
+	w := serve(service, http.MethodPost, "/barycenter", strings.NewReader(body))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "limit of 100 bytes") {
+		t.Errorf("%d bytes: got status %d, want 413: %s", len(body), w.Code, w.Body)
+	}
+}
+
+// blockingBody is an upload that says when it's first read, and then holds off
+// until it's released.
+type blockingBody struct {
+	started, release chan struct{}
+	once             sync.Once
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return 0, io.EOF
+}
+
+func TestServiceMaxConcurrent(t *testing.T) {
+	service := NewService(ServiceOptions{MaxConcurrent: 1})
+	slow := &blockingBody{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan int)
+	go func() {
+		done <- serve(service, http.MethodPost, "/barycenter", slow).Code
+	}()
+	<-slow.started
+
+	// The one slot is taken, so the next request is turned away.
+	w := serve(service, http.MethodPost, "/barycenter", strings.NewReader("1:2:3:4\n"))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("with every slot taken: got status %d, Retry-After %q, want 503 and a Retry-After",
+			w.Code, w.Header().Get("Retry-After"))
+	}
+	close(slow.release)
+	<-done
+
+	// Once the slot is free again, requests are let through.
+	if w := serve(service, http.MethodPost, "/barycenter", strings.NewReader("1:2:3:4\n")); w.Code != http.StatusOK {
+		t.Errorf("with a free slot: got status %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// The timeout is a read deadline on the connection, which a ResponseRecorder doesn't
+// have, so this test goes through a real server.
+func TestServiceTimeout(t *testing.T) {
+	srv := httptest.NewServer(NewService(ServiceOptions{Timeout: 100 * time.Millisecond}))
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("1:2:3:4\n"))
+	resp, err := http.Post(srv.URL+"/barycenter", "text/plain", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var reply serviceError
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestTimeout || !strings.Contains(reply.Error, "longer than 100ms") {
+		t.Errorf("got status %d, error %q, want 408", resp.StatusCode, reply.Error)
+	}
+}
+
+func TestServiceStrictError(t *testing.T) {
+	service := NewService(ServiceOptions{})
+	w := serve(service, http.MethodPost, "/barycenter?strict=true", strings.NewReader("1:2:3:4\n5:6:x:8\n9:10:11:12\n"))
+	var reply serviceError
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity || reply.Line != 2 || reply.Column != 5 || reply.Error == "" {
+		t.Errorf("got status %d, %+v, want 422 on line 2, column 5", w.Code, reply)
+	}
+}
@@ -0,0 +1,201 @@
+package barycenter
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Integrator is a way of stepping a simulation forward in time.
+type Integrator int
+
+const (
+	// Euler moves each body along its old velocity, and updates the velocity from the
+	// acceleration at the old position. It's the simplest, and the least accurate:
+	// the system's energy drifts steadily.
+	Euler Integrator = iota
+	// SymplecticEuler updates the velocities first, and then moves the bodies along the
+	// new ones. It costs the same as Euler, but keeps the energy error bounded.
+	SymplecticEuler
+	// Leapfrog is kick-drift-kick: half a step of velocity, a whole step of position, and
+	// the other half step of velocity from the new positions. It's second order, and still
+	// needs only one tree per step, since the accelerations carry over to the next step.
+	Leapfrog
+)
+
+var integratorNames = [...]string{"euler", "symplectic", "leapfrog"}
+
+func (in Integrator) String() string {
+	if in < 0 || int(in) >= len(integratorNames) {
+		return fmt.Sprintf("Integrator(%d)", int(in))
+	}
+	return integratorNames[in]
+}
+
+// ParseIntegrator looks up an integrator by name: euler, symplectic or leapfrog.
+func ParseIntegrator(name string) (Integrator, error) {
+	for i, n := range integratorNames {
+		if n == name {
+			return Integrator(i), nil
+		}
+	}
+	return 0, fmt.Errorf("barycenter: unknown integrator %q", name)
+}
+
+// A Simulation steps a system of bodies forward in time under their own gravity.
+// Each step builds an Octree over the bodies and works out their accelerations from it,
+// with the work split between the workers.
+type Simulation struct {
+	// Bodies holds the positions and masses of the bodies, and Velocities their velocities.
+	// If Velocities is nil, the bodies start at rest.
+	Bodies     []MassPoint
+	Velocities []Vector
+	Gravity    Gravity
+	Integrator Integrator
+	// Workers is the number of goroutines to build trees and step bodies with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+	// Time is the simulated time so far.
+	Time float64
+
+	// tree and acc are for the bodies' current positions, and are nil once they move.
+	tree *Octree
+	acc  []Vector
+	// spare is the last acceleration slice, kept to be reused.
+	spare []Vector
+	// labels holds the bodies' labels, if they had any, to hand back in State.
+	labels []string
+}
+
+// NewSimulation starts a simulation of bodies, moving at their own velocities.
+// The simulation has its own copy of the bodies.
+func NewSimulation(bodies []Body) *Simulation {
+	s := &Simulation{
+		Bodies:     make([]MassPoint, len(bodies)),
+		Velocities: make([]Vector, len(bodies)),
+	}
+	for i, b := range bodies {
+		s.Bodies[i] = b.MassPoint
+		s.Velocities[i] = b.Velocity
+		if b.Label != "" && s.labels == nil {
+			s.labels = make([]string, len(bodies))
+		}
+		if s.labels != nil {
+			s.labels[i] = b.Label
+		}
+	}
+	return s
+}
+
+// State returns the bodies as they are now, velocities and all.
+func (s *Simulation) State() []Body {
+	bodies := make([]Body, len(s.Bodies))
+	for i, p := range s.Bodies {
+		bodies[i].MassPoint = p
+		if s.Velocities != nil {
+			bodies[i].Velocity = s.Velocities[i]
+		}
+		if s.labels != nil {
+			bodies[i].Label = s.labels[i]
+		}
+	}
+	return bodies
+}
+
+// Tree returns the Octree over the bodies' current positions, building it if need be.
+func (s *Simulation) Tree() *Octree {
+	if s.tree == nil {
+		s.tree = BuildOctree(s.Bodies, s.Workers)
+	}
+	return s.tree
+}
+
+// Barycenter returns the barycenter of the bodies where they are now.
+func (s *Simulation) Barycenter() (MassPoint, error) {
+	return s.Tree().Barycenter()
+}
+
+// accelerations returns the accelerations at the bodies' current positions.
+func (s *Simulation) accelerations() []Vector {
+	if s.acc == nil {
+		s.acc = s.Tree().Accelerations(s.Gravity, s.Workers, s.spare)
+	}
+	return s.acc
+}
+
+// Step advances the simulation by dt.
+func (s *Simulation) Step(dt float64) {
+	if s.Velocities == nil {
+		s.Velocities = make([]Vector, len(s.Bodies))
+	}
+
+	acc := s.accelerations()
+	switch s.Integrator {
+	case Euler:
+		s.each(func(i int) {
+			s.drift(i, dt)
+			s.kick(i, acc[i], dt)
+		})
+		s.moved()
+	case SymplecticEuler:
+		s.each(func(i int) {
+			s.kick(i, acc[i], dt)
+			s.drift(i, dt)
+		})
+		s.moved()
+	default:
+		s.each(func(i int) {
+			s.kick(i, acc[i], dt/2)
+			s.drift(i, dt)
+		})
+		s.moved()
+		acc = s.accelerations()
+		s.each(func(i int) { s.kick(i, acc[i], dt/2) })
+	}
+	s.Time += dt
+}
+
+// kick changes the velocity of body i by accelerating it by a for dt.
+func (s *Simulation) kick(i int, a Vector, dt float64) {
+	v := &s.Velocities[i]
+	v.X += a.X * dt
+	v.Y += a.Y * dt
+	v.Z += a.Z * dt
+}
+
+// drift moves body i along its velocity for dt.
+func (s *Simulation) drift(i int, dt float64) {
+	p, v := &s.Bodies[i], s.Velocities[i]
+	p.X += v.X * dt
+	p.Y += v.Y * dt
+	p.Z += v.Z * dt
+}
+
+// moved forgets the tree and accelerations, once the bodies have moved away from them.
+func (s *Simulation) moved() {
+	s.tree = nil
+	if s.acc != nil {
+		s.spare, s.acc = s.acc, nil
+	}
+}
+
+// each calls fn for every body, splitting the bodies between the workers.
+func (s *Simulation) each(fn func(i int)) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo := len(s.Bodies) * w / workers
+		hi := len(s.Bodies) * (w + 1) / workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,82 @@
+package barycenter
+
+import (
+	"math"
+	"testing"
+)
+
+// With every node opened, each pair of bodies pulls on each other equally and oppositely,
+// so whichever integrator is used, the total momentum shouldn't change: the barycenter
+// keeps moving at the same velocity, in a straight line.
+func TestIntegratorsConserveMomentum(t *testing.T) {
+	bodies := randomBodies(200, 1, 3)
+	start, err := ComputeMotion(bodies, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Momentum is judged against the sum of the sizes of the bodies' momenta.
+	scale := 0.0
+	for _, b := range bodies {
+		scale += math.Abs(b.Mass) * (math.Abs(b.Velocity.X) + math.Abs(b.Velocity.Y) + math.Abs(b.Velocity.Z))
+	}
+	scale /= start.Barycenter.Mass
+
+	for _, integrator := range []Integrator{Euler, SymplecticEuler, Leapfrog} {
+		for _, workers := range []int{1, 4} {
+			s := NewSimulation(bodies)
+			s.Gravity = Gravity{G: 10, Theta: 0, Softening: 0.1}
+			s.Integrator = integrator
+			s.Workers = workers
+			const steps, dt = 10, 0.01
+			for i := 0; i < steps; i++ {
+				s.Step(dt)
+			}
+			m, err := ComputeMotion(s.State(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, v0 := m.Velocity, start.Velocity
+			if d := math.Abs(v.X-v0.X) + math.Abs(v.Y-v0.Y) + math.Abs(v.Z-v0.Z); d > 1e-9*scale {
+				t.Errorf("%s, %d workers: barycenter velocity went from %v to %v", integrator, workers, v0, v)
+			}
+			b, b0 := m.Barycenter, start.Barycenter
+			want := MassPoint{b0.X + v0.X*s.Time, b0.Y + v0.Y*s.Time, b0.Z + v0.Z*s.Time, b0.Mass}
+			if !closeToPoint(b, want) {
+				t.Errorf("%s, %d workers: barycenter is at %v, want %v", integrator, workers, b, want)
+			}
+			if math.Abs(s.Time-steps*dt) > 1e-12 {
+				t.Errorf("%s: time is %g, want %g", integrator, s.Time, steps*dt)
+			}
+		}
+	}
+}
+
+// The bodies should actually move, or conserving momentum proves nothing.
+func TestSimulationMovesBodies(t *testing.T) {
+	bodies := []Body{{MassPoint: MassPoint{-1, 0, 0, 1}}, {MassPoint: MassPoint{1, 0, 0, 1}}}
+	for _, integrator := range []Integrator{Euler, SymplecticEuler, Leapfrog} {
+		s := NewSimulation(bodies)
+		s.Gravity = Gravity{G: 1}
+		s.Integrator = integrator
+		for i := 0; i < 3; i++ {
+			s.Step(0.1)
+		}
+		got := s.State()
+		// The two bodies fall towards each other, symmetrically.
+		if !(got[0].X > -1 && got[0].Velocity.X > 0) || got[0].X != -got[1].X || got[0].Velocity.X != -got[1].Velocity.X {
+			t.Errorf("%s: bodies ended up at %+v", integrator, got)
+		}
+	}
+}
+
+func TestParseIntegrator(t *testing.T) {
+	for _, in := range []Integrator{Euler, SymplecticEuler, Leapfrog} {
+		got, err := ParseIntegrator(in.String())
+		if err != nil || got != in {
+			t.Errorf("ParseIntegrator(%q) = %v, %v", in.String(), got, err)
+		}
+	}
+	if _, err := ParseIntegrator("rk4"); err == nil {
+		t.Error("ParseIntegrator accepted rk4")
+	}
+}
@@ -0,0 +1,250 @@
+package barycenter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Bodies stores mass points as a structure of arrays: a slice for each field, rather than
+// a slice of MassPoints. In the weighted subspace, the pairwise reduction is just a sum of
+// each field on its own, so with the fields in columns each field's sum runs down a plain
+// float64 slice, which the CPU's prefetcher (and any vector instructions the compiler
+// uses) can stream through.
+//
+// The four slices always have the same length.
+type Bodies struct {
+	X, Y, Z, Mass []float64
+}
+
+// NewBodies makes room for n bodies, with none in it yet.
+func NewBodies(n int) Bodies {
+	return Bodies{
+		X:    make([]float64, 0, n),
+		Y:    make([]float64, 0, n),
+		Z:    make([]float64, 0, n),
+		Mass: make([]float64, 0, n),
+	}
+}
+
+// BodiesOf copies points into columns. The columns share one allocation, which is
+// capped between them, so appending to one never runs into the next.
+func BodiesOf(points []MassPoint) Bodies {
+	n := len(points)
+	all := make([]float64, 4*n)
+	b := Bodies{all[:n:n], all[n : 2*n : 2*n], all[2*n : 3*n : 3*n], all[3*n:]}
+	for i, p := range points {
+		b.X[i], b.Y[i], b.Z[i], b.Mass[i] = p.X, p.Y, p.Z, p.Mass
+	}
+	return b
+}
+
+// Len returns the number of bodies.
+func (b Bodies) Len() int { return len(b.Mass) }
+
+// At returns the i'th body as a MassPoint.
+func (b Bodies) At(i int) MassPoint {
+	return MassPoint{b.X[i], b.Y[i], b.Z[i], b.Mass[i]}
+}
+
+// Append adds p to the end of the columns.
+func (b *Bodies) Append(p MassPoint) {
+	b.X = append(b.X, p.X)
+	b.Y = append(b.Y, p.Y)
+	b.Z = append(b.Z, p.Z)
+	b.Mass = append(b.Mass, p.Mass)
+}
+
+func (b *Bodies) addBody(body Body) { b.Append(body.MassPoint) }
+
+// Slice returns the bodies from lo up to hi, sharing their columns with b.
+func (b Bodies) Slice(lo, hi int) Bodies {
+	return Bodies{b.X[lo:hi], b.Y[lo:hi], b.Z[lo:hi], b.Mass[lo:hi]}
+}
+
+// MassPoints copies the bodies back out of their columns.
+func (b Bodies) MassPoints() []MassPoint {
+	points := make([]MassPoint, b.Len())
+	for i := range points {
+		points[i] = b.At(i)
+	}
+	return points
+}
+
+// Reduce finds the barycenter of b, splitting the work between workers goroutines just
+// like the Chunked strategy, and with exactly the same result. If workers is zero or less,
+// GOMAXPROCS is used.
+//
+// Reduce works in place: the columns are weighted by the masses, and each round's sums are
+// written over the front of them, so it allocates nothing, however many rounds there are,
+// but it leaves b scrambled.
+// Use the Columnar strategy to leave the points alone.
+func (b Bodies) Reduce(workers int) (MassPoint, error) {
+	return b.ReduceContext(context.Background(), workers)
+}
+
+// ReduceContext is Reduce, except that its workers give up once ctx is done, and it
+// returns ctx's error.
+func (b Bodies) ReduceContext(ctx context.Context, workers int) (MassPoint, error) {
+	n := b.Len()
+	if n == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	size := chunkSize(n, workers)
+
+	partials := make([]MassPoint, (n+size-1)/size)
+	stop := ctx.Done()
+	var wg sync.WaitGroup
+	for i := range partials {
+		lo := i * size
+		hi := lo + size
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(i int, chunk Bodies) {
+			defer wg.Done()
+			partials[i] = reduceColumns(chunk, stop)
+		}(i, b.Slice(lo, hi))
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return MassPoint{}, err
+	}
+	return checkResult(FromWeightedSubspace(reduceInPlace(partials, nil)))
+}
+
+// reduceColumns is reduceInPlace for columns: it maps them into the weighted subspace, sums
+// each of them pairwise, and returns the weighted sum. The pairs are the same ones
+// reduceInPlace adds up, so the result is bit-for-bit the same.
+// Once stop is closed, it gives up between columns, and the result is meaningless.
+func reduceColumns(b Bodies, stop <-chan struct{}) MassPoint {
+	x, y, z, m := b.X, b.Y, b.Z, b.Mass
+	n := len(m)
+	// Reslicing to the same length lets the compiler drop the bounds checks in the loop.
+	x, y, z = x[:n], y[:n], z[:n]
+	for i, mass := range m {
+		x[i] *= mass
+		y[i] *= mass
+		z[i] *= mass
+	}
+	var sums [4]float64
+	for i, column := range [4][]float64{x, y, z, m} {
+		select {
+		case <-stop:
+			return MassPoint{}
+		default:
+		}
+		sums[i] = sumPairwise(column, 1)
+	}
+	return MassPoint{sums[0], sums[1], sums[2], sums[3]}
+}
+
+// sumPairwise sums every stride'th value of v, from the first, in the same pairwise tree
+// as Linear: each round adds neighbouring pairs, and carries an odd value out to the end.
+// Each round's sums are written over the front of the values, so v is left scrambled.
+// There has to be at least one value.
+func sumPairwise(v []float64, stride int) float64 {
+	if stride == 1 {
+		return sumColumn(v)
+	}
+	n := (len(v) + stride - 1) / stride
+	for n > 1 {
+		half := n / 2
+		for i := 0; i < half; i++ {
+			v[i*stride] = v[2*i*stride] + v[(2*i+1)*stride]
+		}
+		if n%2 != 0 {
+			v[half*stride] = v[(n-1)*stride]
+			half++
+		}
+		n = half
+	}
+	return v[0]
+}
+
+// sumColumn is sumPairwise for a stride of one, which is every column of Bodies. Without
+// the stride to multiply by, the compiler can see the loop never runs off the end.
+func sumColumn(v []float64) float64 {
+	n := len(v)
+	for n > 1 {
+		half := n / 2
+		front, pairs := v[:half], v[:2*half]
+		for i := range front {
+			front[i] = pairs[2*i] + pairs[2*i+1]
+		}
+		if n%2 != 0 {
+			v[half] = v[n-1]
+			half++
+		}
+		n = half
+	}
+	return v[0]
+}
+
+// Columnar is the Chunked strategy working on columns instead of a slice of MassPoints.
+// It copies the points into Bodies, and reduces those in place.
+// Its results are bit-for-bit the same as Chunked's and Linear's.
+type Columnar struct {
+	// Workers is the number of goroutines to reduce with.
+	// If it's zero or less, GOMAXPROCS is used.
+	Workers int
+}
+
+// Compute implements Strategy.
+func (s Columnar) Compute(points []MassPoint) (MassPoint, error) {
+	return s.computeContext(context.Background(), points)
+}
+
+func (s Columnar) computeContext(ctx context.Context, points []MassPoint) (MassPoint, error) {
+	return BodiesOf(points).ReduceContext(ctx, s.Workers)
+}
+
+// LoadColumnsFile is like LoadFile, except the points are loaded straight into columns,
+// ready for Bodies.Reduce.
+func LoadColumnsFile(name string, opts LoadOptions) (Bodies, Rejects, error) {
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	in, err := openInput(name)
+	if err != nil {
+		return Bodies{}, Rejects{}, err
+	}
+	defer in.Close()
+
+	if in.size < 0 || customDecoder(opts) != nil {
+		var b Bodies
+		rejects, err := scanLines(in, opts, &b)
+		if err != nil {
+			return Bodies{}, Rejects{}, err
+		}
+		return b, rejects, nil
+	}
+
+	parts := make([]*Bodies, rangeCount(in.size, opts.Workers))
+	sinks := make([]sink, len(parts))
+	for i := range parts {
+		parts[i] = &Bodies{}
+		sinks[i] = parts[i]
+	}
+	rejects, err := scanRanges(in.ranges(opts), in.size, opts, sinks)
+	if err != nil {
+		return Bodies{}, Rejects{}, err
+	}
+
+	total := 0
+	for _, part := range parts {
+		total += part.Len()
+	}
+	b := NewBodies(total)
+	for _, part := range parts {
+		b.X = append(b.X, part.X...)
+		b.Y = append(b.Y, part.Y...)
+		b.Z = append(b.Z, part.Z...)
+		b.Mass = append(b.Mass, part.Mass...)
+	}
+	return b, rejects, nil
+}
@@ -0,0 +1,87 @@
+package barycenter
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestColumnarMatchesLinearExactly(t *testing.T) {
+	for _, in := range strategyInputs {
+		want, wantErr := Linear{}.Compute(in.points)
+		for _, workers := range []int{1, 2, 3, 8} {
+			got, err := Columnar{Workers: workers}.Compute(in.points)
+			if err != wantErr || got != want {
+				t.Errorf("%s with %d workers: got %v, %v, want %v, %v", in.name, workers, got, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// Reducing in place shouldn't allocate anything per round, so the allocations shouldn't
+// grow with the number of bodies: a thousand times as many bodies is ten more rounds.
+func TestReduceAllocationsDontGrow(t *testing.T) {
+	allocs := func(n int) float64 {
+		columns := BodiesOf(RandomMassPoints(n, 1))
+		work := NewBodies(n)
+		return testing.AllocsPerRun(10, func() {
+			work = Bodies{
+				append(work.X[:0], columns.X...),
+				append(work.Y[:0], columns.Y...),
+				append(work.Z[:0], columns.Z...),
+				append(work.Mass[:0], columns.Mass...),
+			}
+			if _, err := work.Reduce(1); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if small, large := allocs(1000), allocs(1000000); large > small {
+		t.Errorf("reducing 1000 bodies took %v allocations, but 1000000 took %v", small, large)
+	}
+}
+
+// BenchmarkColumnar times the Columnar strategy at each of the benchmark worker counts,
+// to set against BenchmarkChunked, the same reduction over a slice of MassPoints.
+func BenchmarkColumnar(b *testing.B) {
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkStrategy(b, Columnar{Workers: workers})
+		})
+	}
+}
+
+// BenchmarkReduceInPlace compares the two in-place loops on their own, on a single
+// goroutine: the pairwise loop over MassPoints, and the columns. Each run gets a fresh
+// copy of the points, off the clock, and both take them into the weighted subspace.
+func BenchmarkReduceInPlace(b *testing.B) {
+	for _, n := range benchSizes {
+		points := benchPoints(n)
+		b.Run(fmt.Sprintf("aos/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			work := make([]MassPoint, n)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				copy(work, points)
+				b.StartTimer()
+				for j, p := range work {
+					work[j] = ToWeightedSubspace(p)
+				}
+				reduceInPlace(work, nil)
+			}
+		})
+		columns := BodiesOf(benchPoints(n))
+		b.Run(fmt.Sprintf("soa/n=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			work := BodiesOf(benchPoints(n))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				copy(work.X, columns.X)
+				copy(work.Y, columns.Y)
+				copy(work.Z, columns.Z)
+				copy(work.Mass, columns.Mass)
+				b.StartTimer()
+				reduceColumns(work, nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package barycenter
+
+import "errors"
+
+// ErrNoPoints is returned when asked for the barycenter of an empty system.
+var ErrNoPoints = errors.New("barycenter: no mass points to reduce")
+
+// A Strategy reduces a list of mass points down to the single virtual body
+// at the system's barycenter, carrying the system's total mass.
+// Strategies never modify the slice they are given. If the masses add up to zero,
+// they return ErrZeroMass rather than a barycenter made of NaNs.
+type Strategy interface {
+	Compute(points []MassPoint) (MassPoint, error)
+}
+
+// Compute finds the barycenter of points using the Chunked strategy with one worker
+// per available processor.
+func Compute(points []MassPoint) (MassPoint, error) {
+	return Chunked{}.Compute(points)
+}
+
+// Linear is the non-concurrent strategy. It maps the points into the weighted subspace,
+// then every round sums them pairwise into a new list, until there's exactly one point
+// left. Dividing that by the total mass gives the barycenter.
+//
+// Dividing every pair by its own mass instead would go wrong for a pair whose masses
+// cancel out, which negative masses make possible.
+type Linear struct{}
+
+// Compute implements Strategy.
+func (Linear) Compute(points []MassPoint) (MassPoint, error) {
+	if len(points) == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+
+	points = toWeighted(points)
+	for len(points) != 1 {
+		var newPoints []MassPoint
+		for i := 0; i < len(points)-1; i += 2 {
+			newPoints = append(newPoints, AddMassPoints(points[i], points[i+1]))
+		}
+		// Make sure we didn't leave one off
+		if len(points)%2 != 0 {
+			newPoints = append(newPoints, points[len(points)-1])
+		}
+		points = newPoints
+	}
+	return checkResult(FromWeightedSubspace(points[0]))
+}
+
+// toWeighted maps points into the weighted subspace, in a new slice.
+func toWeighted(points []MassPoint) []MassPoint {
+	weighted := make([]MassPoint, len(points))
+	for i, p := range points {
+		weighted[i] = ToWeightedSubspace(p)
+	}
+	return weighted
+}
+
+// Concurrent is the same pairwise reduction as Linear, except that every round
+// spins off a goroutine for each pair of points and collects the results from a channel.
+// Because results are collected in whatever order the goroutines finish, the pairing
+// in later rounds (and so the last few bits of the result) can vary from run to run.
+type Concurrent struct{}
+
+// Compute implements Strategy.
+func (Concurrent) Compute(points []MassPoint) (MassPoint, error) {
+	if len(points) == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+
+	// The larger the buffer, the faster this runs, up to half the size of the input.
+	c := make(chan MassPoint, len(points)/2)
+	points = toWeighted(points)
+	for len(points) > 1 {
+		var newPoints []MassPoint
+		goroutines := 0
+		for i := 0; i < len(points)-1; i += 2 {
+			go addMassPointsAsync(points[i], points[i+1], c)
+			goroutines++
+		}
+
+		for i := 0; i < goroutines; i++ {
+			newPoints = append(newPoints, <-c)
+		}
+
+		if len(points)%2 != 0 {
+			newPoints = append(newPoints, points[len(points)-1])
+		}
+		points = newPoints
+	}
+	return checkResult(FromWeightedSubspace(points[0]))
+}
+
+// addMassPointsAsync is AddMassPoints, except that it passes the result through a channel.
+func addMassPointsAsync(a MassPoint, b MassPoint, c chan<- MassPoint) {
+	c <- AddMassPoints(a, b)
+}
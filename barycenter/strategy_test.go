@@ -0,0 +1,95 @@
+package barycenter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// strategies are the strategies that should all agree with Linear, the reference.
+var strategies = []struct {
+	name     string
+	strategy Strategy
+}{
+	{"linear", Linear{}},
+	{"concurrent", Concurrent{}},
+	{"chunked/1", Chunked{Workers: 1}},
+	{"chunked/3", Chunked{Workers: 3}},
+	{"chunked/8", Chunked{Workers: 8}},
+	{"compensated/1", CompensatedSum{Workers: 1}},
+	{"compensated/4", CompensatedSum{Workers: 4}},
+	{"exact/1", ExactSum{Workers: 1}},
+	{"exact/4", ExactSum{Workers: 4}},
+	{"columnar/1", Columnar{Workers: 1}},
+	{"columnar/4", Columnar{Workers: 4}},
+}
+
+// mixedMassPoints makes n random mass points whose masses are mostly, but not all, positive.
+func mixedMassPoints(n int, seed int64) []MassPoint {
+	rng := rand.New(rand.NewSource(seed))
+	points := make([]MassPoint, n)
+	for i := range points {
+		points[i] = MassPoint{
+			X:    rng.Float64()*200 - 100,
+			Y:    rng.Float64()*200 - 100,
+			Z:    rng.Float64()*200 - 100,
+			Mass: rng.Float64()*6 - 1,
+		}
+	}
+	return points
+}
+
+// strategyInputs are the systems every strategy is run against.
+var strategyInputs = []struct {
+	name    string
+	points  []MassPoint
+	wantErr error
+}{
+	{"empty", nil, ErrNoPoints},
+	{"single body", []MassPoint{{1, 2, 3, 4}}, nil},
+	{"pair", []MassPoint{{0, 0, 0, 1}, {4, 4, 4, 3}}, nil},
+	{"odd count", []MassPoint{{1, 0, 0, 1}, {0, 1, 0, 2}, {0, 0, 1, 3}, {-1, -1, -1, 4}, {5, 5, 5, 5}}, nil},
+	{"zero total mass", []MassPoint{{1, 1, 1, 2}, {2, 2, 2, -1}, {3, 3, 3, -1}}, ErrZeroMass},
+	{"cancelling pair", []MassPoint{{1, 1, 1, 1}, {2, 2, 2, -1}, {3, 3, 3, 3}}, nil},
+	{"mixed masses", mixedMassPoints(1001, 2), nil},
+	{"large random", RandomMassPoints(100003, 1), nil},
+}
+
+// closeTo reports whether got is within a relative tolerance of want.
+func closeTo(got, want float64) bool {
+	scale := math.Max(1, math.Max(math.Abs(got), math.Abs(want)))
+	return math.Abs(got-want) <= 1e-9*scale
+}
+
+func TestStrategiesAgree(t *testing.T) {
+	for _, in := range strategyInputs {
+		want, wantErr := Linear{}.Compute(in.points)
+		if !errors.Is(wantErr, in.wantErr) {
+			t.Fatalf("%s: linear returned error %v, want %v", in.name, wantErr, in.wantErr)
+		}
+		for _, s := range strategies {
+			t.Run(fmt.Sprintf("%s/%s", in.name, s.name), func(t *testing.T) {
+				before := append([]MassPoint(nil), in.points...)
+				got, err := s.strategy.Compute(in.points)
+				if !errors.Is(err, in.wantErr) {
+					t.Fatalf("got error %v, want %v", err, in.wantErr)
+				}
+				if !closeTo(got.X, want.X) || !closeTo(got.Y, want.Y) || !closeTo(got.Z, want.Z) || !closeTo(got.Mass, want.Mass) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				for i := range before {
+					if in.points[i] != before[i] {
+						t.Fatalf("point %d changed from %v to %v", i, before[i], in.points[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkLinear times the Linear strategy, the baseline for BenchmarkPerPair and BenchmarkChunked.
+func BenchmarkLinear(b *testing.B) {
+	benchmarkStrategy(b, Linear{})
+}
@@ -0,0 +1,230 @@
+package barycenter
+
+import (
+	"io"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// A WeightedSum is a running total of mass points in the weighted subspace: the sums of
+// m·x, m·y and m·z, plus the total mass. Points can be folded into it one at a time as
+// they're parsed, so finding a barycenter doesn't need every point in memory at once.
+//
+// The sums are compensated: alongside each one, WeightedSum keeps the low-order bits
+// lost to rounding (Neumaier's variant of Kahan summation), and adds them back in when
+// asked for the barycenter. That keeps the error from growing with the number of points.
+type WeightedSum struct {
+	X, Y, Z, Mass float64
+	// Count is the number of points added.
+	Count int
+
+	// cx, cy, cz and cm are the compensation terms for X, Y, Z and Mass.
+	cx, cy, cz, cm float64
+	// ax, ay, az and am are the sums of the absolute values, for estimating the error.
+	ax, ay, az, am float64
+}
+
+// Add folds a mass point into the sum.
+func (s *WeightedSum) Add(p MassPoint) {
+	mx, my, mz := p.X*p.Mass, p.Y*p.Mass, p.Z*p.Mass
+	neumaierAdd(&s.X, &s.cx, mx)
+	neumaierAdd(&s.Y, &s.cy, my)
+	neumaierAdd(&s.Z, &s.cz, mz)
+	neumaierAdd(&s.Mass, &s.cm, p.Mass)
+	s.ax += math.Abs(mx)
+	s.ay += math.Abs(my)
+	s.az += math.Abs(mz)
+	s.am += math.Abs(p.Mass)
+	s.Count++
+}
+
+func (s *WeightedSum) addBody(b Body) { s.Add(b.MassPoint) }
+
+// remove takes a mass point that was added back out of the sum, by adding the negated
+// products. The compensation keeps the rounding small, but the result isn't always just
+// what the sum would be if it had never held the point: with large and small values
+// mixed, the last few bits can differ. The sums of absolute values can't be taken back,
+// so they stay as they were, and the error bound is an estimate rather than a guarantee
+// once points have been removed. Once the last point is gone, the sum starts afresh.
+func (s *WeightedSum) remove(p MassPoint) {
+	if s.Count <= 1 {
+		*s = WeightedSum{}
+		return
+	}
+	neumaierAdd(&s.X, &s.cx, -p.X*p.Mass)
+	neumaierAdd(&s.Y, &s.cy, -p.Y*p.Mass)
+	neumaierAdd(&s.Z, &s.cz, -p.Z*p.Mass)
+	neumaierAdd(&s.Mass, &s.cm, -p.Mass)
+	s.Count--
+}
+
+// Merge folds another partial sum into this one.
+func (s *WeightedSum) Merge(other WeightedSum) {
+	neumaierAdd(&s.X, &s.cx, other.X)
+	neumaierAdd(&s.Y, &s.cy, other.Y)
+	neumaierAdd(&s.Z, &s.cz, other.Z)
+	neumaierAdd(&s.Mass, &s.cm, other.Mass)
+	s.cx += other.cx
+	s.cy += other.cy
+	s.cz += other.cz
+	s.cm += other.cm
+	s.ax += other.ax
+	s.ay += other.ay
+	s.az += other.az
+	s.am += other.am
+	s.Count += other.Count
+}
+
+// Weighted returns the compensated sums, as a mass point in the weighted subspace.
+func (s WeightedSum) Weighted() MassPoint {
+	return MassPoint{s.X + s.cx, s.Y + s.cy, s.Z + s.cz, s.Mass + s.cm}
+}
+
+// Barycenter returns the virtual body at the barycenter of the points added so far.
+func (s WeightedSum) Barycenter() (MassPoint, error) {
+	if s.Count == 0 {
+		return MassPoint{}, ErrNoPoints
+	}
+	return checkResult(FromWeightedSubspace(s.Weighted()))
+}
+
+// ErrorBound estimates the error in the barycenter of the points added so far.
+func (s WeightedSum) ErrorBound() ErrorBound {
+	result, err := s.Barycenter()
+	if err != nil {
+		return ErrorBound{}
+	}
+	return estimateBound(Compensated, s.Count, s.Weighted(), [4]float64{s.ax, s.ay, s.az, s.am}, result)
+}
+
+// Stream reads body lines from r one at a time, folding them into a WeightedSum
+// instead of keeping them.
+func Stream(r io.Reader, opts LoadOptions) (WeightedSum, Rejects, error) {
+	var sum WeightedSum
+	rejects, err := scanLines(r, opts, &sum)
+	if err != nil {
+		return WeightedSum{}, Rejects{}, err
+	}
+	return sum, rejects, nil
+}
+
+// StreamFile is the streaming version of LoadFile. Uncompressed text and binary files
+// are split into byte ranges with StreamRanges; anything else is read with StreamConcurrent.
+func StreamFile(name string, opts LoadOptions) (WeightedSum, Rejects, error) {
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	in, err := openInput(name)
+	if err != nil {
+		return WeightedSum{}, Rejects{}, err
+	}
+	defer in.Close()
+
+	if in.size < 0 || customDecoder(opts) != nil {
+		return StreamConcurrent(in, opts)
+	}
+	return StreamRanges(in.ranges(opts), in.size, opts)
+}
+
+// StreamRanges is the streaming version of LoadRanges. Each byte range is folded into
+// its own partial sum, and the partial sums are merged in order at the end.
+func StreamRanges(r io.ReaderAt, size int64, opts LoadOptions) (WeightedSum, Rejects, error) {
+	partials := make([]*WeightedSum, rangeCount(size, opts.Workers))
+	sinks := make([]sink, len(partials))
+	for i := range partials {
+		partials[i] = &WeightedSum{}
+		sinks[i] = partials[i]
+	}
+	rejects, err := scanRanges(r, size, opts, sinks)
+	if err != nil {
+		return WeightedSum{}, Rejects{}, err
+	}
+
+	var sum WeightedSum
+	for _, partial := range partials {
+		sum.Merge(*partial)
+	}
+	return sum, rejects, nil
+}
+
+// A streamPartial is everything one streaming worker has seen.
+type streamPartial struct {
+	sum     WeightedSum
+	rejects Rejects
+	err     *ParseError
+}
+
+// StreamConcurrent is the streaming version of LoadConcurrent. Each worker folds every
+// batch it's handed into its own partial sum, so memory use doesn't grow with the input.
+// Since batches go to whichever worker is free, the last few bits of the result can
+// vary from run to run; StreamRanges doesn't have that problem.
+func StreamConcurrent(r io.Reader, opts LoadOptions) (WeightedSum, Rejects, error) {
+	r, opts = opts.track(r)
+	br, isBinary := sniffBinary(r)
+	if isBinary || customDecoder(opts) != nil {
+		return Stream(br, opts)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	batches := make(chan lineBatch, workers)
+	partials := make(chan streamPartial, workers)
+	// done is closed to tell the reader to stop early, after a strict mode failure.
+	done := make(chan struct{})
+	var stop sync.Once
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			var partial streamPartial
+			for batch := range batches {
+				// Once this worker has failed, it just drains the channel.
+				if partial.err != nil {
+					continue
+				}
+				rejects, err := parseLines(batch.lines, batch.index*batchSize+1, opts, &partial.sum)
+				if err != nil {
+					partial.err = err
+					stop.Do(func() { close(done) })
+				}
+				partial.rejects.merge(rejects, opts.MaxExamples)
+			}
+			partials <- partial
+		}()
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		readErr <- readBatches(br, batches, done)
+	}()
+
+	// Every worker saw its batches in file order, so between them their first few
+	// rejects include the first few in the whole file. The same goes for failures.
+	var sum WeightedSum
+	var rejects Rejects
+	var first *ParseError
+	for i := 0; i < workers; i++ {
+		partial := <-partials
+		sum.Merge(partial.sum)
+		rejects.Count += partial.rejects.Count
+		rejects.Examples = append(rejects.Examples, partial.rejects.Examples...)
+		rejects.Flagged += partial.rejects.Flagged
+		rejects.FlaggedExamples = append(rejects.FlaggedExamples, partial.rejects.FlaggedExamples...)
+		if partial.err != nil && (first == nil || partial.err.Line < first.Line) {
+			first = partial.err
+		}
+	}
+	if err := <-readErr; err != nil {
+		return WeightedSum{}, Rejects{}, err
+	}
+	if first != nil {
+		return WeightedSum{}, Rejects{}, first
+	}
+
+	rejects.sortExamples(opts.MaxExamples)
+	return sum, rejects, nil
+}
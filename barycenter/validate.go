@@ -0,0 +1,85 @@
+package barycenter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrZeroMass is returned when the bodies' masses add up to zero, so there's no
+// barycenter to divide out.
+var ErrZeroMass = errors.New("barycenter: system has zero total mass")
+
+// Validation says what loaders do with bodies that parse, but whose values make no sense
+// as a body: masses that are zero or negative, and values that are NaN or infinite.
+type Validation int
+
+const (
+	// RejectInvalid treats such bodies like malformed lines: lenient loads skip them and
+	// count them in Rejects, and strict loads fail on them.
+	RejectInvalid Validation = iota
+	// FlagInvalid keeps such bodies, but counts them in Rejects.Flagged so they can be reported.
+	FlagInvalid
+	// AcceptInvalid keeps such bodies without comment, as loaders did before values were checked.
+	AcceptInvalid
+)
+
+var validationNames = [...]string{"reject", "flag", "accept"}
+
+func (v Validation) String() string {
+	if v < 0 || int(v) >= len(validationNames) {
+		return fmt.Sprintf("Validation(%d)", int(v))
+	}
+	return validationNames[v]
+}
+
+// ParseValidation looks up a validation policy by name: reject, flag or accept.
+func ParseValidation(name string) (Validation, error) {
+	for i, n := range validationNames {
+		if n == name {
+			return Validation(i), nil
+		}
+	}
+	return 0, fmt.Errorf("barycenter: unknown validation policy %q", name)
+}
+
+// checkBody applies the validation policy v to a body that parsed. If one of the body's
+// values is invalid, it returns the index of the field at fault along with an error
+// saying what's wrong, which is marked as flagged if the body should be kept anyway.
+// The caller fills in where the error happened.
+func checkBody(b Body, v Validation) (int, *ParseError) {
+	if v == AcceptInvalid {
+		return 0, nil
+	}
+	field, err := invalidField(b)
+	if err == nil {
+		return 0, nil
+	}
+	return field, &ParseError{Err: err, flagged: v == FlagInvalid}
+}
+
+// invalidField finds the first value of b that a body can't have.
+func invalidField(b Body) (int, error) {
+	p, vel := b.MassPoint, b.Velocity
+	for i, v := range [...]float64{p.X, p.Y, p.Z, p.Mass, vel.X, vel.Y, vel.Z} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return i, fmt.Errorf("%s value %v is not finite", fieldNames[i], v)
+		}
+	}
+	if p.Mass <= 0 {
+		return 3, fmt.Errorf("mass %v is not positive", p.Mass)
+	}
+	return 0, nil
+}
+
+// checkResult makes sure a reduction came up with a barycenter, rather than one made of
+// NaNs from dividing by a total mass of zero, or from invalid values that were let through.
+func checkResult(p MassPoint) (MassPoint, error) {
+	if p.Mass == 0 {
+		return MassPoint{}, ErrZeroMass
+	}
+	if !isFinite(p) {
+		return MassPoint{}, ErrNotFinite
+	}
+	return p, nil
+}
@@ -0,0 +1,66 @@
+package barycenter
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// cancellingBodies has a pair whose masses add up to zero, which only loads under
+// AcceptInvalid. The system's barycenter is at 8/3 on every axis.
+const cancellingBodies = "1:1:1:1\n2:2:2:-1\n3:3:3:3\n"
+
+// Summing pairs in the weighted subspace, and dividing once at the end, is the only way to
+// get this right: dividing the cancelling pair by its own mass throws it away.
+func TestCancellingMassesInEveryStrategy(t *testing.T) {
+	opts := LoadOptions{Validation: AcceptInvalid}
+	points, _, err := Load(strings.NewReader(cancellingBodies), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = 8.0 / 3
+	check := func(name string, coords []float64, mass float64, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		for i, c := range coords {
+			if math.Abs(c-want) > 1e-12 {
+				t.Errorf("%s: coordinate %d is %v, want %v", name, i, c, want)
+			}
+		}
+		if mass != 3 {
+			t.Errorf("%s: mass is %v, want 3", name, mass)
+		}
+	}
+
+	for _, s := range strategies {
+		p, err := s.strategy.Compute(points)
+		check(s.name, []float64{p.X, p.Y, p.Z}, p.Mass, err)
+	}
+	p, err := BodiesOf(points).Reduce(2)
+	check("bodies", []float64{p.X, p.Y, p.Z}, p.Mass, err)
+
+	sum, _, err := Stream(strings.NewReader(cancellingBodies), opts)
+	if err == nil {
+		p, err = sum.Barycenter()
+	}
+	check("stream", []float64{p.X, p.Y, p.Z}, p.Mass, err)
+
+	// The N-dimensional strategies, in three dimensions and in two.
+	for _, dim := range []int{3, 2} {
+		text := cancellingBodies
+		if dim == 2 {
+			text = "1:1:1\n2:2:-1\n3:3:3\n"
+		}
+		nd, _, err := LoadPoints(strings.NewReader(text), dim, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []StrategyN{LinearN{}, ChunkedN{Workers: 1}, ChunkedN{Workers: 2}} {
+			c, err := s.ComputeN(nd)
+			check("N-dimensional", c.Coords, c.Mass, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// baryserver offers the barycenter computation as a service, for programs that would
+// rather make an HTTP request than run concurrentBarycenter on a file. Upload a body file
+// and get a JSON report back:
+//
+//	curl --data-binary @bodies.txt 'http://localhost:8080/barycenter?errorbound=true'
+//
+// net/http already handles every request in its own goroutine. On top of that, each upload
+// is parsed by a pool of workers as it streams in, so the service never holds a whole file
+// in memory, and the limits below keep any one client from taking over the machine.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines to parse each upload with")
+	maxMB := flag.Int64("maxsize", 1024, "largest upload accepted, in MiB; 0 for no limit")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long an upload may take; 0 for no limit")
+	concurrent := flag.Int("concurrent", 4, "how many uploads to work on at once; 0 for no limit")
+	examples := flag.Int("examples", 5, "number of rejected lines to describe in each reply, by default")
+	flag.Parse()
+
+	server := &http.Server{
+		Addr: *addr,
+		Handler: barycenter.NewService(barycenter.ServiceOptions{
+			Workers:       *workers,
+			MaxBytes:      *maxMB << 20,
+			Timeout:       *timeout,
+			MaxConcurrent: *concurrent,
+			MaxExamples:   *examples,
+		}),
+		// Clients that never get round to sending their headers don't count against
+		// -concurrent, so they get a deadline of their own.
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Fprintf(os.Stderr, "Serving barycenters on %s.\n", *addr)
+	handle(server.ListenAndServe())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// bodyconv converts body files between the text format written by genBodies and the
+// binary format. The input format is detected automatically, so the only thing to
+// choose is the output format.
+//
+// Usage: bodyconv [-to=binary|text] [-velocity] [-invalid=reject|flag|accept] input output
+//
+// Either file name can be "-" for standard input or output.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	toName := flag.String("to", "binary", "output format: text or binary")
+	strict := flag.Bool("strict", false, "fail on the first malformed line instead of skipping it")
+	velocity := flag.Bool("velocity", false, "keep the bodies' velocities")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		fmt.Println("Usage: bodyconv [-to=binary|text] [-invalid=reject|flag|accept] input output")
+		os.Exit(1)
+	}
+	to, err := barycenter.ParseFormat(*toName)
+	handle(err)
+	validation, err := barycenter.ParseValidation(*invalid)
+	handle(err)
+
+	in := os.Stdin
+	if flag.Arg(0) != "-" {
+		in, err = os.Open(flag.Arg(0))
+		handle(err)
+		defer in.Close()
+	}
+	out := os.Stdout
+	if flag.Arg(1) != "-" {
+		out, err = os.Create(flag.Arg(1))
+		handle(err)
+		defer out.Close()
+	}
+
+	// We don't know how many bodies there are until we've read them all, so the binary
+	// writer leaves the count in the header to be filled in once we're done.
+	w := bufio.NewWriter(out)
+	var bw *barycenter.BinaryWriter
+	if to == barycenter.Binary {
+		if *velocity {
+			bw, err = barycenter.NewBodyWriter(out, -1)
+		} else {
+			bw, err = barycenter.NewBinaryWriter(out, -1)
+		}
+		handle(err)
+	}
+
+	// WalkBodies hands us the bodies one at a time, in file order, so nothing is held in memory.
+	var line []byte
+	var writeErr error
+	opts := barycenter.LoadOptions{
+		Name:        flag.Arg(0),
+		Strict:      *strict,
+		MaxExamples: 5,
+		Validation:  validation,
+	}
+	rejects, err := barycenter.WalkBodies(in, opts,
+		func(b barycenter.Body) {
+			if writeErr != nil {
+				return
+			}
+			if bw != nil {
+				writeErr = bw.WriteBody(b)
+				return
+			}
+			if *velocity {
+				line = barycenter.AppendBodyText(line[:0], b)
+			} else {
+				line = barycenter.AppendText(line[:0], b.MassPoint)
+			}
+			_, writeErr = w.Write(line)
+		})
+	handle(err)
+	handle(writeErr)
+
+	if bw != nil {
+		handle(bw.Flush())
+	} else {
+		handle(w.Flush())
+	}
+
+	if rejects.Count > 0 || rejects.Flagged > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+	if rejects.Count > 0 {
+		os.Exit(3)
+	}
+}
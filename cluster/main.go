@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// cluster goes one step past a single barycenter: it splits the bodies into k clusters
+// with mass-weighted k-means, and reports the barycenter of each. Heavier bodies pull
+// their cluster's center harder, just as they pull the barycenter.
+//
+//	cluster -k 8 -assignments clustered.txt bodies.txt
+//
+// Every round, each worker assigns its share of the bodies to their nearest centers and
+// sums up its share of each cluster, and the sums are merged to give the new centers.
+// The same seed always gives the same clusters, whatever the number of workers.
+//
+// The assignments are written as a body file, with each body labelled by its cluster,
+// so concurrentBarycenter -bylabel can check the barycenters of the clusters.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
+}
+
+// Like the other commands, a partial load still gets clustered, but exits with its own code.
+const (
+	exitFailure     = 1
+	exitPartialLoad = 3
+)
+
+// writeAssignments writes the points to the file called name, each labelled with its cluster.
+func writeAssignments(name string, points []barycenter.MassPoint, assignments []int) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var line []byte
+	for i, p := range points {
+		line = barycenter.AppendText(line[:0], p)
+		line[len(line)-1] = ':'
+		line = strconv.AppendInt(line, int64(assignments[i]), 10)
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	k := flag.Int("k", 8, "number of clusters to find")
+	seed := flag.Int64("seed", 1, "seed for picking the starting centers")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines to load and cluster with")
+	iterations := flag.Int("iterations", barycenter.DefaultMaxIterations, "most rounds of k-means to run")
+	assignments := flag.String("assignments", "", "write each body, labelled with its cluster, to this file")
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	examples := flag.Int("examples", 5, "number of rejected lines to show in lenient mode")
+	outputName := flag.String("output", "text", "how to print the clusters: text, json or csv")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Incorrect number of arguments!")
+		os.Exit(exitFailure)
+	}
+	output, err := barycenter.ParseOutput(*outputName)
+	handle(err)
+	validation, err := barycenter.ParseValidation(*invalid)
+	handle(err)
+
+	start := time.Now()
+	points, rejects, err := barycenter.LoadFile(flag.Arg(0), barycenter.LoadOptions{
+		Workers:     *workers,
+		Strict:      *strict,
+		MaxExamples: *examples,
+		Validation:  validation,
+	})
+	handle(err)
+	if rejects.Count > 0 || rejects.Flagged > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+	loaded := time.Since(start)
+
+	start = time.Now()
+	c, err := barycenter.KMeans(points, barycenter.ClusterOptions{
+		K:             *k,
+		Seed:          *seed,
+		Workers:       *workers,
+		MaxIterations: *iterations,
+	})
+	handle(err)
+	clustered := time.Since(start)
+
+	handle(barycenter.WriteGroups(os.Stdout, c.Clusters, barycenter.Grouping{ByLabel: true}, output))
+	// The summary goes to stderr unless we're printing text, so it doesn't get in the way.
+	w := os.Stdout
+	if output != barycenter.TextOutput {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "Loaded %d values from file in %s.\n", len(points), loaded)
+	if len(c.Clusters) < *k {
+		fmt.Fprintf(w, "Found only %d distinct positions with mass, so there are %d clusters rather than %d.\n",
+			len(c.Clusters), len(c.Clusters), *k)
+	}
+	verb := "Converged"
+	if !c.Converged {
+		verb = "Stopped without converging"
+	}
+	fmt.Fprintf(w, "%s after %d rounds in %s; the weighted sum of squared distances is %g.\n",
+		verb, c.Iterations, clustered, c.Inertia)
+
+	if *assignments != "" {
+		handle(writeAssignments(*assignments, points, c.Assignments))
+	}
+	if rejects.Count > 0 {
+		os.Exit(exitPartialLoad)
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"sync"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/internal/cli"
 )
 
 // In this video, we'll make the barycenter program we wrote in the last video concurrent.
@@ -16,187 +25,377 @@ import (
 // ST
 // Pull up your editor and open the program.
 
-type MassPoint struct {
-	x, y, z, mass float64
-}
+// The MassPoint type and the weighted averaging math now live in the barycenter package,
+// which gives us both the linear strategy and a concurrent one. Choosing the decoder,
+// checking what we loaded and the exit codes come from the cli package, just like in
+// linearBarycenter.
 
-func addMassPoints(a MassPoint, b MassPoint) MassPoint {
-	return MassPoint{
-		a.x + b.x,
-		a.y + b.y,
-		a.z + b.z,
-		a.mass + b.mass,
+func handle(err error) {
+	if err != nil {
+		panic(err)
 	}
 }
 
-func avgMassPoints(a MassPoint, b MassPoint) MassPoint {
-	sum := addMassPoints(a, b)
-	return MassPoint{
-		sum.x / 2,
-		sum.y / 2,
-		sum.z / 2,
-		sum.mass,
-	}
+// timeStrategy runs a strategy over the points and reports how long it took to w.
+func timeStrategy(w io.Writer, name string, s barycenter.Strategy, masspoints []barycenter.MassPoint) {
+	start := time.Now()
+	_, err := s.Compute(masspoints)
+	handle(err)
+	fmt.Fprintf(w, "%s strategy took %s.\n", name, time.Since(start))
 }
 
-func toWeightedSubspace(a MassPoint) MassPoint {
-	return MassPoint{
-		a.x * a.mass,
-		a.y * a.mass,
-		a.z * a.mass,
-		a.mass,
-	}
-}
+// drift lets the bodies coast along their velocities for the given number of steps, and
+// reports where the barycenter has got to after each one. Nothing pulls on the bodies, so
+// the barycenter should move in a straight line at the velocity it started with; how far
+// it strays from that line is down to rounding.
+func drift(ctx context.Context, w io.Writer, bodies []barycenter.Body, start barycenter.Motion, steps int, dt float64, workers int) {
+	for step := 1; step <= steps; step++ {
+		cli.ExitOnCancel(ctx.Err())
+		barycenter.Advance(bodies, dt, workers)
+		m, err := barycenter.ComputeMotion(bodies, workers)
+		cli.ExitOnNoBarycenter(err)
 
-func fromWeightedSubspace(a MassPoint) MassPoint {
-	return MassPoint{
-		a.x / a.mass,
-		a.y / a.mass,
-		a.z / a.mass,
-		a.mass,
+		t := float64(step) * dt
+		dx := m.Barycenter.X - (start.Barycenter.X + start.Velocity.X*t)
+		dy := m.Barycenter.Y - (start.Barycenter.Y + start.Velocity.Y*t)
+		dz := m.Barycenter.Z - (start.Barycenter.Z + start.Velocity.Z*t)
+		fmt.Fprintf(w, "Step %d (t=%.6g): barycenter at (%f, %f, %f), %g off a straight line.\n",
+			step, t, m.Barycenter.X, m.Barycenter.Y, m.Barycenter.Z, math.Sqrt(dx*dx+dy*dy+dz*dz))
 	}
 }
 
-func avgMassPointsWeighted(a MassPoint, b MassPoint) MassPoint {
-	aWeighted := toWeightedSubspace(a)
-	bWeighted := toWeightedSubspace(b)
-	return fromWeightedSubspace(avgMassPoints(aWeighted, bWeighted))
+// startProgress prints a progress line to stderr every interval, with how many lines
+// have been parsed, how fast, and, if the size of the input is known, how long there is
+// to go. It returns a function that stops it.
+func startProgress(p *barycenter.Progress, every time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		start := time.Now()
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			elapsed := time.Since(start)
+			lines := p.Lines()
+			msg := fmt.Sprintf("Parsed %d lines in %s, %.0f lines/s", lines, elapsed.Round(100*time.Millisecond), float64(lines)/elapsed.Seconds())
+			if read, total := p.Bytes(), p.Total(); total > 0 && read > 0 {
+				frac := float64(read) / float64(total)
+				eta := time.Duration(float64(elapsed) * (1 - frac) / frac)
+				msg += fmt.Sprintf(", %.1f%% done, about %s to go", 100*frac, eta.Round(100*time.Millisecond))
+			}
+			fmt.Fprintln(os.Stderr, msg+".")
+		}
+	}()
+	return func() { close(done) }
 }
 
-// The first thing we need to do is make an async version of the loading procedure.
-// It takes a string to work on, a channel through which to send results, and a WaitGroup pointer.
-func stringToPointAsync(s string, c chan<- MassPoint, wg *sync.WaitGroup) {
-	// First off, we'll defer the WaitGroup finishing operation, so however this function exits
-	// it will notify the WaitGroup that it's done.
-	defer wg.Done()
-	// We'll create a new MassPoint to hold the result
-	var newMassPoint MassPoint
-	// Then we'll use Sscanf to parse the line
-	_, err := fmt.Sscanf(s, "%f:%f:%f:%f", &newMassPoint.x, &newMassPoint.y, &newMassPoint.z, &newMassPoint.mass)
-	// If there's an error, just abort
-	if err != nil {
-		return
+// parseVector parses a vector given on the command line, either as x,y,z or as a single
+// value for all three.
+func parseVector(s string) (barycenter.Vector, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return barycenter.Vector{}, fmt.Errorf("bad vector %q: want x,y,z or a single value", s)
+	}
+	var v [3]float64
+	for i := range v {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i%len(parts)]), 64)
+		if err != nil {
+			return barycenter.Vector{}, fmt.Errorf("bad vector %q: %v", s, err)
+		}
 	}
-	// If there wasn't an error, send the result through the channel
-	c <- newMassPoint
+	return barycenter.Vector{X: v[0], Y: v[1], Z: v[2]}, nil
 }
 
-// Now we need an async version of the actual computation.
-// This will be exactly the same as the avgMassPointsWeighted except that it takes a channel
-// and passes the result through that channel.
-// No need for fancy WaitGroup syncronization, since this can't fail.
-func avgMassPointsWeightedAsync(a MassPoint, b MassPoint, c chan<- MassPoint) {
-	c <- avgMassPointsWeighted(a, b)
-}
+func main() {
+	// The number of workers loading and reduction are split between defaults to one per processor.
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines to load and reduce with")
+	compare := flag.Bool("compare", false, "also time the linear, per-pair goroutine and columnar strategies")
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	examples := flag.Int("examples", 5, "number of rejected lines to show in lenient mode")
+	stream := flag.Bool("stream", false, "fold points into running sums instead of loading them all")
+	precisionName := flag.String("precision", "pairwise", "how to sum the barycenter: pairwise, compensated or exact")
+	errorBound := flag.Bool("errorbound", false, "report the estimated error bound alongside the result")
+	format := flag.String("format", "auto", "input format: auto, text, binary, csv, csv-noheader or ndjson")
+	columns := flag.String("columns", "", "columns or fields to read, like x=lon,y=lat,z=alt,mass=4")
+	outputName := flag.String("output", "text", "how to print the result: text, json or csv")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	velocity := flag.Bool("velocity", false, "also report how fast the barycenter is moving")
+	steps := flag.Int("steps", 0, "let the bodies coast along their velocities for this many steps, reporting the barycenter")
+	dt := flag.Float64("dt", 1, "length of each step for -steps")
+	moments := flag.Bool("moments", false, "also report the inertia tensor, principal axes, radius of gyration and variance")
+	byLabel := flag.Bool("bylabel", false, "report a barycenter for each label instead of the whole system")
+	grid := flag.String("grid", "", "report a barycenter for each cell of a grid with cells this size, like 10 or 10,10,5; 0 leaves an axis unsplit")
+	origin := flag.String("origin", "0", "a corner of grid cell (0, 0, 0), as x,y,z")
+	timeout := flag.Duration("timeout", 0, "give up after this long; 0 for no limit")
+	progressEvery := flag.Duration("progress", 0, "print a progress line to stderr this often while loading; 0 for none")
+	maxOpen := flag.Int("maxopen", barycenter.DefaultMaxOpen, "how many files to load at once, when given several")
+	buffered := flag.Bool("buffered", false, "read files through buffers instead of mapping them into memory")
+	flag.Parse()
+	// Like the strategies, we take zero or fewer workers to mean one per processor, and
+	// that's the number the report gives.
+	if *workers <= 0 {
+		*workers = runtime.GOMAXPROCS(0)
+	}
 
-func handle(err error) {
-	if err != nil {
-		panic(err)
+	if flag.NArg() < 1 {
+		fmt.Println("Incorrect number of arguments!")
+		os.Exit(cli.ExitFailure)
 	}
-}
 
-func closeFile(fi *os.File) {
-	err := fi.Close()
-	handle(err)
-}
+	// The arguments can be files, globs or directories, which stand for every file in them.
+	names, err := barycenter.ExpandPaths(flag.Args())
+	multi := len(names) > 1
 
-func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Incorrect number of arguments!")
-		os.Exit(1)
+	// Streams always fold points into compensated sums, so the other precisions need the points loaded.
+	var precision barycenter.Precision
+	if err == nil {
+		precision, err = barycenter.ParsePrecision(*precisionName)
+	}
+	if err == nil && *stream && precision != barycenter.Compensated && cli.PrecisionSet() {
+		err = errors.New("-stream always uses compensated precision")
+	}
+	// Velocities need the bodies loaded too.
+	moving := *velocity || *steps > 0
+	if err == nil && *stream && moving {
+		err = errors.New("-velocity and -steps can't be used with -stream")
+	}
+	// So do the moments, which take a second pass over the bodies.
+	if err == nil && *stream && *moments {
+		err = errors.New("-moments can't be used with -stream")
+	}
+	// Groups are folded into running sums, one per group, like a stream.
+	grouping := barycenter.Grouping{ByLabel: *byLabel}
+	if err == nil && *grid != "" {
+		grouping.Grid, err = parseVector(*grid)
+	}
+	if err == nil {
+		grouping.Origin, err = parseVector(*origin)
+	}
+	grouped := *byLabel || *grid != ""
+	if err == nil && grouped && (moving || *compare || *errorBound || *moments) {
+		err = errors.New("-bylabel and -grid can't be used with -velocity, -steps, -compare, -errorbound or -moments")
+	}
+	// With several files, we just find barycenters.
+	if err == nil && multi && (grouped || moving || *compare || *errorBound || *moments) {
+		err = errors.New("-bylabel, -grid, -velocity, -steps, -compare, -errorbound and -moments only work on a single file")
+	}
+	var decoder barycenter.Decoder
+	if err == nil {
+		decoder, err = cli.ChooseDecoder(*format, *columns, names[0])
+	}
+	var output barycenter.Output
+	if err == nil {
+		output, err = barycenter.ParseOutput(*outputName)
+	}
+	var validation barycenter.Validation
+	if err == nil {
+		validation, err = barycenter.ParseValidation(*invalid)
+	}
+	if err != nil {
+		cli.Fail(err)
 	}
 
-	file, err := os.Open(os.Args[1])
-	handle(err)
-	defer closeFile(file)
+	// Ctrl-C cancels the context, which the loaders and the strategy keep an eye on, so
+	// their workers wind down instead of running to the end. After that, Ctrl-C goes back
+	// to killing the program outright, in case anything is stuck.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	var masspoints []MassPoint
+	opts := barycenter.LoadOptions{
+		Workers:     *workers,
+		Strict:      *strict,
+		MaxExamples: *examples,
+		Decoder:     decoder,
+		Validation:  validation,
+		Context:     ctx,
+		Buffered:    *buffered,
+	}
+	stopProgress := func() {}
+	if *progressEvery > 0 {
+		opts.Progress = &barycenter.Progress{}
+		stopProgress = startProgress(opts.Progress, *progressEvery)
+	}
 
+	// Everything we learn along the way goes into a report, which is printed at the end.
+	report := barycenter.Report{Workers: *workers}
 	startLoading := time.Now()
 
-	// Now we need to modify the file parsing logic.
-	// Rather than scanf, we'll use the function we created earlier, along with a buffered reader.
-	r := bufio.NewReader(file)
-	// We also need a buffered channel for results
-	masspointsChan := make(chan MassPoint, 128)
-	// And a waitgroup for syncronization
-	var wg sync.WaitGroup
-	for {
-		// To actually get a line, we'll use the ReadString function
-		str, err := r.ReadString('\n')
-		// If the result is empty or there's an error, there are no more lines to read
-		if len(str) == 0 || err != nil {
-			break
+	// With several files, up to -maxopen of them are loaded at once, each split between the
+	// workers just like a single file would be, and each gets a barycenter of its own.
+	// The system's barycenter is then found from the files' barycenters, each of which
+	// carries its file's mass.
+	if multi {
+		fo := barycenter.FilesOptions{LoadOptions: opts, MaxOpen: *maxOpen}
+		report.Strategy = "stream"
+		if !*stream {
+			fo.Strategy = precision.Strategy(*workers)
+			report.Strategy = "chunked"
+			if precision != barycenter.Pairwise {
+				report.Strategy = precision.String()
+			}
 		}
+		cli.ComputeFiles(names, fo, *format, *columns, report, output, stopProgress)
+		return
+	}
 
-		// Otherwise, we'll start off a goroutine to parse the line
-		wg.Add(1)
-		go stringToPointAsync(str, masspointsChan, &wg)
-	}
-
-	// Now we'll set up syncronization. We need a channel for this, unbuffered.
-	syncChan := make(chan bool)
-	// Then we'll run a goroutine which will send a value through this channel when
-	// the WaitGroup finishes.
-	go func() { wg.Wait(); syncChan <- false }()
-
-	// Finally,  we'll receive the values in a loop
-	// We'll have a boolean value telling us if the computations are still running
-	run := true
-	// If they're still running, or there are values in the channel, keep receiving values
-	for run || len(masspointsChan) > 0 {
-		select {
-		// If a value is available, we'll put it in the masspoints buffer
-		case val := <-masspointsChan:
-			masspoints = append(masspoints, val)
-			// If the computations are done, we'll toggle the switch off
-		case _ = <-syncChan:
-			run = false
+	// For groups, each worker keeps a running weighted sum per group for its part of the file.
+	// Then the groups are shared out between the workers by key, and each one merges the
+	// sums for its groups from every part: map-reduce, with the key being the group.
+	if grouped {
+		groups, rejects, err := barycenter.GroupFile(names[0], opts, grouping)
+		stopProgress()
+		if _, ok := err.(*barycenter.ParseError); ok {
+			cli.ExitOnParseError(err)
+		}
+		cli.ExitOnNoBarycenter(err)
+		if rejects.Count > 0 || rejects.Flagged > 0 {
+			rejects.WriteSummary(os.Stderr)
+		}
+		handle(barycenter.WriteGroups(os.Stdout, groups, grouping, output))
+		if output == barycenter.TextOutput {
+			fmt.Printf("Found %d groups in %s.\n", len(groups), time.Since(startLoading))
 		}
-	}
 
-	fmt.Printf("Loaded %d values from file in %s.\n", len(masspoints), time.Since(startLoading))
-	if len(masspoints) <= 1 {
-		handle(errors.New("Insufficient number of values; there must be at least one "))
+		if rejects.Count > 0 {
+			os.Exit(cli.ExitPartialLoad)
+		}
+		return
 	}
 
-	// Just before the processing loop, we'll create a channel.
-	// It'll be buffered, and the larger the buffer, the faster the program will run,
-	// up to half the size of the input.
-	c := make(chan MassPoint, len(masspoints)/2)
+	// In streaming mode, each worker folds the points from its part of the file into its own
+	// running weighted sum, and the sums are merged at the end. Nothing else is kept, so memory
+	// use stays the same however large the file is.
+	if *stream {
+		sum, rejects, err := barycenter.StreamFile(names[0], opts)
+		stopProgress()
+		cli.ExitOnParseError(err)
+		report.Load = time.Since(startLoading)
+		cli.CheckLoaded(sum.Count, rejects)
 
-	startCalculation := time.Now()
-	for len(masspoints) > 1 {
-		var newMasspoints []MassPoint
-		// We need a new variable here to keep track of how many goroutines we've
-		// spun off.
-		goroutines := 0
-		for i := 0; i < len(masspoints)-1; i += 2 {
-			// Now, rather than doing the actual processing here, we'll just spin off a goroutine
-			// for each pair of points.
-			go avgMassPointsWeightedAsync(masspoints[i], masspoints[i+1], c)
-			goroutines++
+		report.Barycenter, err = sum.Barycenter()
+		cli.ExitOnNoBarycenter(err)
+		report.Bodies = sum.Count
+		report.Rejected = rejects.Count
+		report.Flagged = rejects.Flagged
+		report.Strategy = "stream"
+		if *errorBound {
+			bound := sum.ErrorBound()
+			report.ErrorBound = &bound
 		}
+		handle(report.Write(os.Stdout, output))
 
-		// Now that all the goroutines are running, we'll recieve from them in a loop.
-		for i := 0; i < goroutines; i++ {
-			newMasspoints = append(newMasspoints, <-c)
+		if rejects.Count > 0 {
+			os.Exit(cli.ExitPartialLoad)
 		}
+		return
+	}
 
-		if len(masspoints)%2 != 0 {
-			newMasspoints = append(newMasspoints, masspoints[len(masspoints)-1])
-		}
+	// Loading is concurrent too. The loader splits the file into one byte range per worker,
+	// lets each worker read and parse its own range, and puts the results back together
+	// in file order, so the result doesn't depend on how the workers happen to be scheduled.
+	// For velocities, we load whole bodies, and hand just their mass points to the strategies.
+	// If all we want is the pairwise barycenter, nothing needs the points once they're
+	// reduced, so we load them straight into columns and reduce those in place.
+	columnar := precision == barycenter.Pairwise && !moving && !*errorBound && !*moments && !*compare
+	var bodies []barycenter.Body
+	var masspoints []barycenter.MassPoint
+	var soa barycenter.Bodies
+	var rejects barycenter.Rejects
+	if moving {
+		bodies, rejects, err = barycenter.LoadBodiesFile(names[0], opts)
+		cli.ExitOnParseError(err)
+		masspoints = barycenter.MassPoints(bodies)
+	} else if columnar {
+		soa, rejects, err = barycenter.LoadColumnsFile(names[0], opts)
+		cli.ExitOnParseError(err)
+	} else {
+		masspoints, rejects, err = barycenter.LoadFile(names[0], opts)
+		cli.ExitOnParseError(err)
+	}
+	stopProgress()
+	report.Load = time.Since(startLoading)
+	report.Bodies = len(masspoints)
+	if columnar {
+		report.Bodies = soa.Len()
+	}
+	cli.CheckLoaded(report.Bodies, rejects)
+	report.Rejected = rejects.Count
+	report.Flagged = rejects.Flagged
+
+	startCalculation := time.Now()
+	// Rather than spinning off a goroutine for each pair of points in every round, we hand the
+	// points to the chunked strategy, which gives each worker one slice of the points to reduce.
+	// The other precisions split the points between the workers in the same way.
+	// Columns are split between the workers just like the chunked strategy does it, and
+	// give exactly the same result, only faster.
+	if columnar {
+		report.Barycenter, err = soa.ReduceContext(ctx, *workers)
+	} else {
+		report.Barycenter, err = barycenter.ComputeContext(ctx, precision.Strategy(*workers), masspoints)
+	}
+	cli.ExitOnNoBarycenter(err)
+	report.Compute = time.Since(startCalculation)
+
+	switch {
+	case columnar:
+		report.Strategy = "columnar"
+	case precision == barycenter.Pairwise:
+		report.Strategy = "chunked"
+	default:
+		report.Strategy = precision.String()
+	}
+	if *errorBound {
+		bound := barycenter.EstimateError(masspoints, report.Barycenter, precision)
+		report.ErrorBound = &bound
+	}
+	var motion barycenter.Motion
+	if moving {
+		motion, err = barycenter.ComputeMotion(bodies, *workers)
+		cli.ExitOnNoBarycenter(err)
+		report.Velocity = &motion.Velocity
+	}
+	// The second moments are summed about a running mean, Welford-style, so each worker
+	// can make its pass over its own chunk of the points, and the chunks merged after.
+	if *moments {
+		m, err := barycenter.ComputeMoments(masspoints, *workers)
+		cli.ExitOnNoBarycenter(err)
+		report.Moments = &m
+	}
+	handle(report.Write(os.Stdout, output))
 
-		masspoints = newMasspoints
+	// Anything else we print goes to stderr, unless we're printing text,
+	// so it doesn't get in the way.
+	var w io.Writer = os.Stdout
+	if output != barycenter.TextOutput {
+		w = os.Stderr
 	}
-	systemAverage := masspoints[0]
 
-	fmt.Printf("System barycenter is at (%f, %f, %f) and the system's mass is %f.\n",
-		systemAverage.x,
-		systemAverage.y,
-		systemAverage.z,
-		systemAverage.mass)
-	fmt.Printf("Calculation took %s.\n", time.Since(startCalculation))
+	// To see what the worker pool buys us, we can run the other strategies over the same points.
+	if *compare {
+		timeStrategy(w, "Linear", barycenter.Linear{}, masspoints)
+		timeStrategy(w, "Per-pair goroutine", barycenter.Concurrent{}, masspoints)
+		timeStrategy(w, "Columnar", barycenter.Columnar{Workers: *workers}, masspoints)
+	}
+	if *steps > 0 {
+		drift(ctx, w, bodies, motion, *steps, *dt, *workers)
+	}
+
+	if rejects.Count > 0 {
+		os.Exit(cli.ExitPartialLoad)
+	}
 }
 
 // Running this program, you should see a noted decrease in both loading and computation time.
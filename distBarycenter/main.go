@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// distBarycenter takes the concurrent barycenter past one machine's cores. It runs in
+// one of two modes:
+//
+//	distBarycenter -listen :7001
+//	    serves as a worker, folding every shard it's sent into a partial weighted sum.
+//	distBarycenter -peers host1:7001,host2:7001 file...
+//	    serves as the coordinator, splitting the files into shards, sending them to the
+//	    workers, and merging the partial sums that come back.
+//
+// If a worker goes down or stops answering, its shard goes to another worker, and the
+// coordinator checks in on it now and then, in case it comes back. Try it with a few
+// workers on localhost, and kill one of them partway through.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
+}
+
+// A partial load still prints a barycenter, but exits with its own code,
+// just like concurrentBarycenter.
+const (
+	exitFailure     = 1
+	exitPartialLoad = 3
+)
+
+func main() {
+	listen := flag.String("listen", "", "run as a worker, serving shards on this address, like :7001")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines a worker parses each shard with")
+	peers := flag.String("peers", "", "comma-separated worker addresses to send shards to")
+	shardMB := flag.Int64("shard", barycenter.DefaultShardSize>>20, "shard size in MiB")
+	timeout := flag.Duration("timeout", time.Minute, "how long a worker gets to answer a shard; 0 for no limit")
+	attempts := flag.Int("attempts", 3, "how many times to try each shard")
+	giveUp := flag.Duration("giveup", time.Minute, "how long to wait for a failed worker to come back")
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	examples := flag.Int("examples", 5, "number of rejected lines to show in lenient mode")
+	outputName := flag.String("output", "text", "how to print the result: text, json or csv")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	errorBound := flag.Bool("errorbound", false, "report the estimated error bound alongside the result")
+	flag.Parse()
+
+	if *listen != "" {
+		fmt.Fprintf(os.Stderr, "Serving shards on %s with %d workers.\n", *listen, *workers)
+		handle(http.ListenAndServe(*listen, barycenter.NewShardHandler(*workers)))
+		return
+	}
+
+	if *peers == "" || flag.NArg() == 0 {
+		fmt.Println("Usage: distBarycenter -listen addr, or distBarycenter -peers addr,... file...")
+		os.Exit(exitFailure)
+	}
+	output, err := barycenter.ParseOutput(*outputName)
+	handle(err)
+	validation, err := barycenter.ParseValidation(*invalid)
+	handle(err)
+
+	c := &barycenter.Coordinator{
+		Peers:        strings.Split(*peers, ","),
+		ShardSize:    *shardMB << 20,
+		ShardTimeout: *timeout,
+		MaxAttempts:  *attempts,
+		GiveUpAfter:  *giveUp,
+		Log:          os.Stderr,
+	}
+	start := time.Now()
+	sum, rejects, err := c.Run(flag.Args(), barycenter.LoadOptions{
+		Strict:      *strict,
+		MaxExamples: *examples,
+		Validation:  validation,
+	})
+	if perr, ok := err.(*barycenter.ParseError); ok {
+		fmt.Fprintln(os.Stderr, perr)
+		os.Exit(exitFailure)
+	}
+	handle(err)
+	if rejects.Count > 0 || rejects.Flagged > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+
+	report := barycenter.Report{
+		Bodies:   sum.Count,
+		Rejected: rejects.Count,
+		Flagged:  rejects.Flagged,
+		Load:     time.Since(start),
+		Workers:  len(c.Peers),
+		Strategy: "distributed",
+	}
+	report.Barycenter, err = sum.Barycenter()
+	handle(err)
+	if *errorBound {
+		bound := sum.ErrorBound()
+		report.ErrorBound = &bound
+	}
+	handle(report.Write(os.Stdout, output))
+
+	if rejects.Count > 0 {
+		os.Exit(exitPartialLoad)
+	}
+}
@@ -1,48 +1,99 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"math/rand"
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
 )
 
 // This simple command line utility will fit entirely in the main() function.
 func main() {
+	// The bodies can be written in the text format, or in the much faster to load binary format.
+	formatName := flag.String("format", "text", "output format: text or binary")
+	// Bodies can be given a random velocity too, up to this much in any axis.
+	velMax := flag.Int("velocity", 0, "give each body a random velocity of up to this much in each axis")
+	seed := flag.Int64("seed", 0, "seed for the random numbers; 0 to seed from the clock")
+	flag.Parse()
+	format, err := barycenter.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	// First, we just check if there are enough arguments.
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		// If not, print an error and exit.
 		fmt.Println("genBodies requires at least one argument: the number of points to generate.")
 		os.Exit(1)
 	}
 
 	// Then, we'll get the number to generate from the command line arguments.
-	nBodies, err := strconv.Atoi(os.Args[1])
+	nBodies, err := strconv.Atoi(flag.Arg(0))
 	// If the user didn't enter a number, exit.
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Now we'll seed the RNG with the current time...
-	rand.Seed(time.Now().Unix())
-	// and set the maximum deviation from the center in any axis, and the maximum mass
-	posMax := 100
-	massMax := 5
+	// Now we'll seed the RNG with the current time, unless we've been given a seed to
+	// repeat an earlier run with. The bounds of the bodies live in the barycenter package,
+	// along with the generator itself, so that barybench can generate the same kind of input.
+	if *seed == 0 {
+		*seed = time.Now().Unix()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	// Output is buffered, since we'll be writing a lot of small records.
+	out := bufio.NewWriter(os.Stdout)
+	var bw *barycenter.BinaryWriter
+	if format == barycenter.Binary {
+		if *velMax > 0 {
+			bw, err = barycenter.NewBodyWriter(out, int64(nBodies))
+		} else {
+			bw, err = barycenter.NewBinaryWriter(out, int64(nBodies))
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	// Now we just generate lines in a loop and print them.
 	for i := 0; i < nBodies; i++ {
-		// Each position is at most posMax away from the origin in that axis.
-		// Go doesn't have functions to generate negative random integers,
-		// so we generate a positive integer with twice the range and subtract.
-		posX := rand.Intn(posMax*2) - posMax
-		posY := rand.Intn(posMax*2) - posMax
-		posZ := rand.Intn(posMax*2) - posMax
-		// On the other hand, mass can't be negative (or zero), so this is easier.
-		mass := rand.Intn(massMax-1) + 1
-		// Now we print them out in a very simple format with colon seperation.
-		fmt.Printf("%d:%d:%d:%d\n", posX, posY, posZ, mass)
+		// Each position is at most GenPosMax away from the origin in each axis.
+		body := barycenter.RandomBody(rng, *velMax)
+		// In binary, each body is a record of packed floats.
+		if bw != nil {
+			err = bw.WriteBody(body)
+		} else if *velMax > 0 {
+			// The velocity goes on the end of the line.
+			_, err = fmt.Fprintf(out, "%g:%g:%g:%g:%g:%g:%g\n", body.X, body.Y, body.Z, body.Mass,
+				body.Velocity.X, body.Velocity.Y, body.Velocity.Z)
+		} else {
+			// Otherwise we print them out in a very simple format with colon seperation.
+			_, err = fmt.Fprintf(out, "%g:%g:%g:%g\n", body.X, body.Y, body.Z, body.Mass)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if bw != nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
 
@@ -0,0 +1,152 @@
+// Package cli holds what linearBarycenter and concurrentBarycenter have in common: picking
+// a decoder and checking -precision from their flags, working through several files at
+// once, and telling scripts how things went through the exit code.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// A partial load still prints a barycenter, but exits with its own code
+// so scripts can tell it apart from a complete one.
+const (
+	ExitFailure     = 1
+	ExitPartialLoad = 3
+	// ExitInterrupted is what shells use for a program killed by SIGINT.
+	ExitInterrupted = 130
+)
+
+// Fail prints err to stderr and exits with ExitFailure.
+func Fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(ExitFailure)
+}
+
+// ExitOnCancel reports a run that was interrupted, or ran out of time, and aborts.
+// The loaders and the strategies have already stopped their workers by the time they
+// hand back the context's error.
+func ExitOnCancel(err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(os.Stderr, "Interrupted.")
+		os.Exit(ExitInterrupted)
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintln(os.Stderr, "Ran out of time.")
+		os.Exit(ExitFailure)
+	}
+}
+
+// ExitOnParseError reports a malformed line from a strict load, which says exactly where
+// the file went wrong, and aborts. Any other error is a bug, and panics.
+func ExitOnParseError(err error) {
+	ExitOnCancel(err)
+	if perr, ok := err.(*barycenter.ParseError); ok {
+		Fail(perr)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ExitOnNoBarycenter reports a system that has no barycenter, like one whose masses
+// add up to zero, and aborts. Printing a barycenter made of NaNs wouldn't help anyone.
+// Any other error panics.
+func ExitOnNoBarycenter(err error) {
+	ExitOnCancel(err)
+	if errors.Is(err, barycenter.ErrZeroMass) || errors.Is(err, barycenter.ErrNotFinite) {
+		Fail(err)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// CheckLoaded summarizes any points that were skipped, and checks that there's at least
+// one to work with. A single body is its own barycenter.
+func CheckLoaded(n int, rejects barycenter.Rejects) {
+	if rejects.Count > 0 || rejects.Flagged > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+	if n == 0 {
+		Fail(errors.New("Insufficient number of values; there must be at least one"))
+	}
+}
+
+// PrecisionSet reports whether -precision was given on the command line.
+func PrecisionSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == "precision" })
+	return set
+}
+
+// ChooseDecoder picks the input decoder named by -format, or the one that suits the
+// file's extension if the format is auto. columns is the -columns mapping.
+func ChooseDecoder(format, columns, path string) (barycenter.Decoder, error) {
+	cols, err := barycenter.ParseColumnMap(columns)
+	if err != nil {
+		return nil, err
+	}
+	if format == "auto" {
+		return barycenter.DecoderForPath(path, cols), nil
+	}
+	return barycenter.DecoderByName(format, cols)
+}
+
+// ComputeFiles finds the barycenter of each of several files, and the barycenter of all
+// of them together, which it finds from the files' barycenters: each one carries its
+// file's mass, so it stands in for the whole file. It prints them all, and exits if any
+// lines were skipped or anything went wrong.
+//
+// fo says how the files are loaded and reduced, and report already has the workers and
+// strategy filled in; the rest of it is filled in here. If the format is auto, each file's
+// decoder is picked by its own extension. loaded, if it's not nil, is called as soon as
+// the files are loaded, to stop a progress display, say.
+func ComputeFiles(names []string, fo barycenter.FilesOptions, format, columns string,
+	report barycenter.Report, output barycenter.Output, loaded func()) {
+	if format == "auto" {
+		// The first file's decoder has been checked already, and the others can't fail
+		// any differently.
+		fo.DecoderFor = func(name string) barycenter.Decoder {
+			d, _ := ChooseDecoder(format, columns, name)
+			return d
+		}
+	}
+
+	start := time.Now()
+	files, rejects, err := barycenter.ComputeFiles(names, fo)
+	if loaded != nil {
+		loaded()
+	}
+	if _, ok := err.(*barycenter.ParseError); ok {
+		ExitOnParseError(err)
+	}
+	ExitOnNoBarycenter(err)
+	// Each file's barycenter is found as soon as it's loaded, so that's counted as
+	// loading, and the calculation is just combining them.
+	report.Load = time.Since(start)
+	for _, f := range files {
+		report.Bodies += f.Bodies
+	}
+	CheckLoaded(report.Bodies, rejects)
+	report.Rejected = rejects.Count
+	report.Flagged = rejects.Flagged
+
+	start = time.Now()
+	report.Barycenter, err = barycenter.CombineFiles(files, fo.Strategy)
+	ExitOnNoBarycenter(err)
+	report.Compute = time.Since(start)
+	if err := barycenter.WriteFiles(os.Stdout, files, report, output); err != nil {
+		panic(err)
+	}
+
+	if rejects.Count > 0 {
+		os.Exit(ExitPartialLoad)
+	}
+}
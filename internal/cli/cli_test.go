@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+func TestChooseDecoder(t *testing.T) {
+	cols := barycenter.DefaultColumns
+	cols.Mass = "4"
+	for _, tc := range []struct {
+		format, path string
+		want         barycenter.Decoder
+	}{
+		{"auto", "bodies.txt", barycenter.TextDecoder{}},
+		{"auto", "bodies.CSV.gz", barycenter.CSVDecoder{Columns: cols}},
+		{"auto", "bodies.jsonl", barycenter.NDJSONDecoder{Columns: cols}},
+		// A format given by name wins over the extension.
+		{"ndjson", "bodies.csv", barycenter.NDJSONDecoder{Columns: cols}},
+		{"text", "bodies.csv", barycenter.TextDecoder{}},
+	} {
+		got, err := ChooseDecoder(tc.format, "mass=4", tc.path)
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.format, tc.path, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %s: got %#v, want %#v", tc.format, tc.path, got, tc.want)
+		}
+	}
+	if _, err := ChooseDecoder("yaml", "", "bodies.txt"); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+	if _, err := ChooseDecoder("auto", "weight=4", "bodies.txt"); err == nil {
+		t.Error("a bad column mapping was accepted")
+	}
+}
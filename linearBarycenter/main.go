@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/internal/cli"
 )
 
 // In this video, we'll implement a non-concurrent, non-linear version of the barycenter finder.
@@ -18,68 +22,11 @@ import (
 // ST (editor - genBodies)
 // Once back here:
 // Now it's time for the actual barycenter finder.
-// The first thing to do is to create the struct we'll use to represent a body.
-
-// We'll call it a MassPoint. It's a point in 3-space plus mass information.
-// The MassPoint is the primary datastructure we'll pass around through the program.
-type MassPoint struct {
-	x, y, z, mass float64
-}
-
-// We can define a function that just adds them together.
-// It'll add each coordinate, and the masses.
-func addMassPoints(a MassPoint, b MassPoint) MassPoint {
-	// We just return a new MassPoint where we've added in each coordinate
-	return MassPoint{
-		a.x + b.x,
-		a.y + b.y,
-		a.z + b.z,
-		a.mass + b.mass,
-	}
-}
-
-// Using that function, we can create a function that averages them.
-func avgMassPoints(a MassPoint, b MassPoint) MassPoint {
-	// All we have to do is add them together and divide by two.
-	sum := addMassPoints(a, b)
-	// So we divide by two in each coordinate
-	return MassPoint{
-		sum.x / 2,
-		sum.y / 2,
-		sum.z / 2,
-		// But not in the mass
-		sum.mass,
-	}
-}
-
-// Then, we need a function that maps them to a different point in space by mass, as we discussed.
-func toWeightedSubspace(a MassPoint) MassPoint {
-	return MassPoint{
-		a.x * a.mass,
-		a.y * a.mass,
-		a.z * a.mass,
-		a.mass,
-	}
-}
-
-// And we need a function that takes them back.
-func fromWeightedSubspace(a MassPoint) MassPoint {
-	return MassPoint{
-		a.x / a.mass,
-		a.y / a.mass,
-		a.z / a.mass,
-		a.mass,
-	}
-}
+// The first thing we need is a way to represent a body.
 
-// Finally, we'll write a function which takes a pair of mass points and returns the
-// weighted average.
-func avgMassPointsWeighted(a MassPoint, b MassPoint) MassPoint {
-	// First we calculate the weighted version of both mass points
-	aWeighted := toWeightedSubspace(a)
-	bWeighted := toWeightedSubspace(b)
-	return fromWeightedSubspace(avgMassPoints(aWeighted, bWeighted))
-}
+// We'll call it a MassPoint. It's a point in 3-space plus mass information, and it lives
+// in the barycenter package along with the math for averaging MassPoints by weight,
+// so that other programs can import it too.
 
 // Now, on to the actual application code. First, we'll define two useful helper functions.
 
@@ -91,91 +38,192 @@ func handle(err error) {
 }
 
 // And another that will close a file, so we can defer that operation.
-func closeFile(fi *os.File) {
+func closeFile(fi io.Closer) {
 	err := fi.Close()
 	handle(err)
 }
 
+// We also need to tell scripts apart whether the barycenter covers every line in the file,
+// and to say clearly what went wrong when the input is no good. concurrentBarycenter
+// needs all the same things, so they live in a small package the two of them share, cli.
+
 // Now comes the actual bulk of our program, in the main function.
 func main() {
-	// Check arguments. We need exactly two (the executable name and one user-provided argument).
-	if len(os.Args) != 2 {
-		// If there are too many or not enough, abort.
+	// By default malformed lines are skipped and summarized; -strict makes them fatal.
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	examples := flag.Int("examples", 5, "number of rejected lines to show in lenient mode")
+	stream := flag.Bool("stream", false, "fold points into a running sum instead of loading them all")
+	precisionName := flag.String("precision", "pairwise", "how to sum the barycenter: pairwise, compensated or exact")
+	errorBound := flag.Bool("errorbound", false, "report the estimated error bound alongside the result")
+	format := flag.String("format", "auto", "input format: auto, text, binary, csv, csv-noheader or ndjson")
+	columns := flag.String("columns", "", "columns or fields to read, like x=lon,y=lat,z=alt,mass=4")
+	outputName := flag.String("output", "text", "how to print the result: text, json or csv")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	velocity := flag.Bool("velocity", false, "also report how fast the barycenter is moving")
+	flag.Parse()
+
+	// Check arguments. We need at least one user-provided argument, the file name.
+	if flag.NArg() < 1 {
+		// If there aren't any, abort.
 		fmt.Println("Incorrect number of arguments!")
-		os.Exit(1)
+		os.Exit(cli.ExitFailure)
+	}
+
+	// There can be more than one, too: files, globs, or directories of files.
+	names, err := barycenter.ExpandPaths(flag.Args())
+	multi := len(names) > 1
+
+	// Streams always fold points into compensated sums, so the other precisions need the points loaded.
+	var precision barycenter.Precision
+	if err == nil {
+		precision, err = barycenter.ParsePrecision(*precisionName)
+	}
+	if err == nil && *stream && precision != barycenter.Compensated && cli.PrecisionSet() {
+		err = errors.New("-stream always uses compensated precision")
+	}
+	if err == nil && *stream && *velocity {
+		err = errors.New("-velocity can't be used with -stream")
+	}
+	if err == nil && multi && (*velocity || *errorBound) {
+		err = errors.New("-velocity and -errorbound only work on a single file")
+	}
+	var decoder barycenter.Decoder
+	if err == nil {
+		decoder, err = cli.ChooseDecoder(*format, *columns, names[0])
+	}
+	var output barycenter.Output
+	if err == nil {
+		output, err = barycenter.ParseOutput(*outputName)
+	}
+	var validation barycenter.Validation
+	if err == nil {
+		validation, err = barycenter.ParseValidation(*invalid)
+	}
+	if err != nil {
+		cli.Fail(err)
 	}
 
-	// Then, we'll open the input file with os.Open
-	file, err := os.Open(os.Args[1])
+	// Several files are worked through one after another: no concurrency here, so one file
+	// open at a time, and one worker to load it. Each gets a barycenter of its own, and the
+	// system's barycenter is found from those.
+	if multi {
+		fo := barycenter.FilesOptions{
+			LoadOptions: barycenter.LoadOptions{
+				Workers:     1,
+				Strict:      *strict,
+				MaxExamples: *examples,
+				Decoder:     decoder,
+				Validation:  validation,
+			},
+			MaxOpen: 1,
+		}
+		report := barycenter.Report{Workers: 1, Strategy: "stream"}
+		if !*stream {
+			fo.Strategy = barycenter.Linear{}
+			report.Strategy = "linear"
+			if precision != barycenter.Pairwise {
+				fo.Strategy = precision.Strategy(1)
+				report.Strategy = precision.String()
+			}
+		}
+		cli.ComputeFiles(names, fo, *format, *columns, report, output, nil)
+		return
+	}
+
+	// Then, we'll open the input file. OpenInput treats "-" as standard input,
+	// and decompresses gzip-compressed files on the way in.
+	file, err := barycenter.OpenInput(names[0])
 	// Handle a possible error using our error handler
 	handle(err)
 	// And finally defer the closing of the file,
 	// so even if the program aborts the file will still get closed.
 	defer closeFile(file)
 
-	// Now we need initial buffer for the MassPoints, which we'll load from the file.
-	var masspoints []MassPoint
-
-	// We'll time how long it takes to load them, just for comparison.
-	startLoading := time.Now()
-	// Let's make an infinite loop for loading, and then break out when there are no more points
-	// to read.
-	for {
-		// We'll create a variable to hold the new point
-		var newMassPoint MassPoint
-		// Then we'll use fmt.Fscanf to load a single line from the file
-		_, err = fmt.Fscanf(file, "%f:%f:%f:%f", &newMassPoint.x, &newMassPoint.y, &newMassPoint.z, &newMassPoint.mass)
-		// If we got an EOF error, there are no more points to load
-		if err == io.EOF {
-			break
-			// On other errors, we can just skip the line.
-		} else if err != nil {
-			continue
-		}
-		// Finally, we'll use append() to add the point into the list of points.
-		masspoints = append(masspoints, newMassPoint)
-	}
-
-	// Now we'll report how many points we loaded
-	fmt.Printf("Loaded %d values from file in %s.\n", len(masspoints), time.Since(startLoading))
-	// And we should check that there are actually enough values.
-	if len(masspoints) <= 1 {
-		// If there aren't enough, we'll create an error and pass it to our error handler.
-		handle(errors.New("Insufficient number of values; there must be at least one "))
+	opts := barycenter.LoadOptions{
+		Name:        names[0],
+		Strict:      *strict,
+		MaxExamples: *examples,
+		Decoder:     decoder,
+		Validation:  validation,
 	}
 
-	// We also want to time the calculation itself, so we'll start a timer.
-	startCalculation := time.Now()
-
-	// Now, we'll make a loop. It'll run until there's exactly one point left.
-	for len(masspoints) != 1 {
-		// Each loop will need a new array of MassPoints
-		var newMasspoints []MassPoint
+	// Everything we learn along the way goes into a report, which we print at the end.
+	// This version doesn't do anything concurrently, so there's just the one worker.
+	report := barycenter.Report{Workers: 1}
 
-		// We loop over the current list of bodies by twos
-		for i := 0; i < len(masspoints)-1; i += 2 {
-			// Adding the results of the averaging to the new array of mass points
-			newMasspoints = append(newMasspoints, avgMassPointsWeighted(masspoints[i], masspoints[i+1]))
+	// We'll time how long it takes to load the points, just for comparison.
+	startLoading := time.Now()
+	var rejects barycenter.Rejects
+	if *stream {
+		// In streaming mode, each point is folded into a running weighted sum as it's parsed
+		// and then thrown away, so memory use stays the same however large the file is.
+		var sum barycenter.WeightedSum
+		sum, rejects, err = barycenter.Stream(file, opts)
+		cli.ExitOnParseError(err)
+		report.Load = time.Since(startLoading)
+		cli.CheckLoaded(sum.Count, rejects)
+
+		report.Barycenter, err = sum.Barycenter()
+		cli.ExitOnNoBarycenter(err)
+		report.Bodies = sum.Count
+		report.Strategy = "stream"
+		if *errorBound {
+			bound := sum.ErrorBound()
+			report.ErrorBound = &bound
 		}
+	} else {
+		// Otherwise, the barycenter package's Load reads the file one line at a time.
+		// If we want the barycenter's velocity, LoadBodies keeps the bodies' velocities too.
+		var masspoints []barycenter.MassPoint
+		var bodies []barycenter.Body
+		if *velocity {
+			bodies, rejects, err = barycenter.LoadBodies(file, opts)
+			cli.ExitOnParseError(err)
+			masspoints = barycenter.MassPoints(bodies)
+		} else {
+			masspoints, rejects, err = barycenter.Load(file, opts)
+			cli.ExitOnParseError(err)
+		}
+		report.Load = time.Since(startLoading)
+		cli.CheckLoaded(len(masspoints), rejects)
+		report.Bodies = len(masspoints)
+
+		// We also want to time the calculation itself, so we'll start a timer.
+		startCalculation := time.Now()
+
+		// The barycenter package's Linear strategy averages the points pairwise, round after round,
+		// until there's exactly one virtual body left. The other precisions sum the points
+		// in a single pass, on a single worker here.
+		var strategy barycenter.Strategy = barycenter.Linear{}
+		report.Strategy = "linear"
+		if precision != barycenter.Pairwise {
+			strategy = precision.Strategy(1)
+			report.Strategy = precision.String()
+		}
+		report.Barycenter, err = strategy.Compute(masspoints)
+		cli.ExitOnNoBarycenter(err)
+		report.Compute = time.Since(startCalculation)
 
-		// Then we check to make sure we didn't leave off one
-		if len(masspoints)%2 != 0 {
-			newMasspoints = append(newMasspoints, masspoints[len(masspoints)-1])
+		if *errorBound {
+			bound := barycenter.EstimateError(masspoints, report.Barycenter, precision)
+			report.ErrorBound = &bound
 		}
 
-		// Finally, we need to switch out the old array with the new one
-		masspoints = newMasspoints
+		// The barycenter's velocity is the system's momentum over its mass.
+		if *velocity {
+			motion, err := barycenter.ComputeMotion(bodies, 1)
+			cli.ExitOnNoBarycenter(err)
+			report.Velocity = &motion.Velocity
+		}
 	}
 
-	// Once the loop is done we need the one remaining virtual body
-	systemAverage := masspoints[0]
-
-	// And then we'll print out the result in a pretty way.
-	fmt.Printf("System barycenter is at (%f, %f, %f) and the system's mass is %f.\n",
-		systemAverage.x,
-		systemAverage.y,
-		systemAverage.z,
-		systemAverage.mass)
-	// Finally, we just want to print out the time the calculation has taken.
-	fmt.Printf("Calculation took %s.\n", time.Since(startCalculation))
+	// And then we'll print out the report, in whichever form was asked for.
+	report.Rejected = rejects.Count
+	report.Flagged = rejects.Flagged
+	handle(report.Write(os.Stdout, output))
+
+	// If we skipped any lines, the result only covers part of the file.
+	if rejects.Count > 0 {
+		os.Exit(cli.ExitPartialLoad)
+	}
 }
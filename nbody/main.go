@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// nbody takes the bodies in a body file and lets them move under their own gravity.
+// Every step builds a Barnes-Hut octree whose nodes hold the barycenters of the bodies
+// under them, and works out every body's acceleration from the tree concurrently.
+//
+// Usage: nbody [flags] input
+//
+// The bodies start at the velocities given in the file, or at rest if there aren't any.
+// Snapshots are written in the body file format, velocities included, so a run can be
+// picked up where it left off: with -out, every -every steps to files named by the
+// pattern (like snap%04d.txt, filled in with the step number), and otherwise just the
+// final state, to standard output.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// writeSnapshot writes the bodies to the file the pattern names for this step.
+func writeSnapshot(pattern string, step int, bodies []barycenter.Body, format barycenter.Format) error {
+	name := pattern
+	if strings.Contains(pattern, "%") {
+		name = fmt.Sprintf(pattern, step)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := barycenter.WriteMovingBodies(f, bodies, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// reportBarycenter prints where the system's barycenter is after a step. Momentum is
+// conserved, so the barycenter should move in a straight line (or, for bodies starting
+// at rest, not at all); how far it wanders is a rough measure of the error from the tree
+// and the integrator.
+func reportBarycenter(sim *barycenter.Simulation, step int) {
+	b, err := sim.Barycenter()
+	handle(err)
+	fmt.Fprintf(os.Stderr, "Step %d (t=%.6g): barycenter at (%f, %f, %f).\n", step, sim.Time, b.X, b.Y, b.Z)
+}
+
+func main() {
+	steps := flag.Int("steps", 100, "number of steps to simulate")
+	dt := flag.Float64("dt", 0.01, "length of each step")
+	integratorName := flag.String("integrator", "leapfrog", "how to step the bodies: euler, symplectic or leapfrog")
+	theta := flag.Float64("theta", 0.5, "Barnes-Hut opening angle; 0 sums over every pair")
+	g := flag.Float64("g", 1, "gravitational constant")
+	softening := flag.Float64("softening", 0.1, "softening length added to every distance")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines to build trees and step with")
+	every := flag.Int("every", 0, "report the barycenter, and write a snapshot with -out, every this many steps; 0 for just the last")
+	out := flag.String("out", "", "snapshot file name pattern, like snap%04d.txt; empty writes the last step to standard output")
+	formatName := flag.String("format", "text", "snapshot format: text or binary")
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: nbody [flags] input")
+		os.Exit(1)
+	}
+	integrator, err := barycenter.ParseIntegrator(*integratorName)
+	handle(err)
+	format, err := barycenter.ParseFormat(*formatName)
+	handle(err)
+
+	bodies, rejects, err := barycenter.LoadBodiesFile(flag.Arg(0), barycenter.LoadOptions{
+		Workers:     *workers,
+		Strict:      *strict,
+		MaxExamples: 5,
+	})
+	handle(err)
+	if rejects.Count > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+	if len(bodies) == 0 {
+		handle(barycenter.ErrNoPoints)
+	}
+
+	sim := barycenter.NewSimulation(bodies)
+	sim.Gravity = barycenter.Gravity{G: *g, Theta: *theta, Softening: *softening}
+	sim.Integrator = integrator
+	sim.Workers = *workers
+
+	start := time.Now()
+	reportBarycenter(sim, 0)
+	for step := 1; step <= *steps; step++ {
+		sim.Step(*dt)
+		if *every > 0 && step%*every == 0 {
+			reportBarycenter(sim, step)
+			if *out != "" {
+				handle(writeSnapshot(*out, step, sim.State(), format))
+			}
+		}
+	}
+	if *every <= 0 || *steps%*every != 0 {
+		reportBarycenter(sim, *steps)
+	}
+	fmt.Fprintf(os.Stderr, "Simulated %d bodies for %d steps in %s.\n", len(bodies), *steps, time.Since(start))
+
+	switch {
+	case *out == "":
+		handle(barycenter.WriteMovingBodies(os.Stdout, sim.State(), format))
+	case *every <= 0 || *steps%*every != 0:
+		handle(writeSnapshot(*out, *steps, sim.State(), format))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/PacktPublishing/Hands-on-Concurrency-with-Go-video/barycenter"
+)
+
+// ndBarycenter is concurrentBarycenter for points with any number of coordinates:
+// 2 for map data, or a few dozen for feature vectors. Each line of the input is the
+// point's coordinates followed by its mass, separated by colons, as in
+//
+//	# dim 2
+//	51.5:-0.12:8900000
+//	48.85:2.35:2100000
+//
+// The header is optional; without it, the number of dimensions is worked out from the
+// first line. The points are reduced pairwise, just like the 3D version, either linearly
+// or split between workers, and 3D inputs take the same fast path as concurrentBarycenter.
+
+func handle(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitFailure)
+	}
+}
+
+// Like the other commands, a partial load still prints a barycenter, but exits with its own code.
+const (
+	exitFailure     = 1
+	exitPartialLoad = 3
+)
+
+func main() {
+	dim := flag.Int("dim", 0, "number of coordinates per point; 0 to read it from the file")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of goroutines to reduce with")
+	linear := flag.Bool("linear", false, "reduce the points linearly, on a single goroutine")
+	strict := flag.Bool("strict", false, "fail on the first malformed line")
+	examples := flag.Int("examples", 5, "number of rejected lines to show in lenient mode")
+	outputName := flag.String("output", "text", "how to print the result: text, json or csv")
+	invalid := flag.String("invalid", "reject", "what to do with non-positive masses and NaN or infinite values: reject, flag or accept")
+	flag.Parse()
+	// Like the strategies, we take zero or fewer workers to mean one per processor, and
+	// that's the number the report gives.
+	if *workers <= 0 {
+		*workers = runtime.GOMAXPROCS(0)
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Println("Incorrect number of arguments!")
+		os.Exit(exitFailure)
+	}
+	if *dim < 0 || *dim > barycenter.MaxDim {
+		handle(fmt.Errorf("-dim must be from 1 to %d, or 0", barycenter.MaxDim))
+	}
+	output, err := barycenter.ParseOutput(*outputName)
+	handle(err)
+	validation, err := barycenter.ParseValidation(*invalid)
+	handle(err)
+
+	file, err := barycenter.OpenInput(flag.Arg(0))
+	handle(err)
+	defer file.Close()
+
+	report := barycenter.ReportN{Workers: *workers, Strategy: "chunked"}
+	var strategy barycenter.StrategyN = barycenter.ChunkedN{Workers: *workers}
+	if *linear {
+		report.Workers, report.Strategy = 1, "linear"
+		strategy = barycenter.LinearN{}
+	}
+
+	start := time.Now()
+	points, rejects, err := barycenter.LoadPoints(file, *dim, barycenter.LoadOptions{
+		Name:        flag.Arg(0),
+		Strict:      *strict,
+		MaxExamples: *examples,
+		Validation:  validation,
+	})
+	handle(err)
+	report.Load = time.Since(start)
+	if rejects.Count > 0 || rejects.Flagged > 0 {
+		rejects.WriteSummary(os.Stderr)
+	}
+	if points.Len() == 0 {
+		handle(errors.New("no points to find the barycenter of"))
+	}
+	report.Bodies, report.Rejected, report.Flagged = points.Len(), rejects.Count, rejects.Flagged
+
+	start = time.Now()
+	report.Barycenter, err = strategy.ComputeN(points)
+	handle(err)
+	report.Compute = time.Since(start)
+	handle(report.Write(os.Stdout, output))
+
+	if rejects.Count > 0 {
+		os.Exit(exitPartialLoad)
+	}
+}